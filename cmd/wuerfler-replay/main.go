@@ -0,0 +1,96 @@
+// wuerfler-replay regenerates the rolls of a seeded room from its seed and roll log as returned by
+// GET /api/rooms/{room}/log. It exits with status 1 if any result differs from the logged one
+//
+//	wuerfler-replay [log.json]
+//
+// The log is read from stdin if no file is given
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/m0ppers/wuerfler/rooms"
+)
+
+func main() {
+	var input io.Reader = os.Stdin
+	if len(os.Args) > 1 {
+		file, err := os.Open(os.Args[1])
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer file.Close()
+		input = file
+	}
+
+	var rollLog rooms.RollLog
+	if err := json.NewDecoder(input).Decode(&rollLog); err != nil {
+		log.Fatalf("Invalid log: %v", err)
+	}
+	replayed, err := rooms.Replay(rollLog)
+	mismatches := 0
+	for i, r := range replayed {
+		status := "ok"
+		if !r.Matches {
+			status = "MISMATCH logged " + results(r.Entry.Results)
+			mismatches++
+		}
+		fmt.Printf("%4d %s %s\n", i, describe(r), status)
+	}
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if rollLog.Truncated {
+		fmt.Println("The log has been truncated. Later rolls can't be replayed")
+	}
+	fmt.Printf("Replayed %d entries with seed %d, %d mismatches\n", len(replayed), rollLog.Seed, mismatches)
+	if mismatches > 0 {
+		os.Exit(1)
+	}
+}
+
+func describe(r rooms.Replayed) string {
+	entry := r.Entry
+	date := entry.Date.Format("2006-01-02 15:04:05")
+	switch entry.Type {
+	case rooms.LogSettings:
+		return fmt.Sprintf("%s settings (%s)", date, entry.Settings.System)
+	case rooms.LogRoll:
+		roll := entry.Roll.Expression
+		if roll == "" {
+			roll = strings.Join(entry.Roll.Dice, " ")
+		}
+		if entry.Roll.Mode != "" {
+			roll += " " + entry.Roll.Mode
+		}
+		return fmt.Sprintf("%s %s rolled %s: %s", date, entry.RollerID, roll, results(r.Results))
+	case rooms.LogTable:
+		return fmt.Sprintf("%s %s rolled on table %s: %s", date, entry.RollerID, entry.TableName, results(r.Results))
+	case rooms.LogMacro:
+		return fmt.Sprintf("%s %s ran macro %s: %s", date, entry.RollerID, entry.Macro.Name, results(r.Results))
+	case rooms.LogShuffle:
+		return fmt.Sprintf("%s shuffled %d cards", date, entry.Cards)
+	}
+	return date + " " + entry.Type
+}
+
+func results(results []rooms.RollResult) string {
+	values := make([]string, 0, len(results))
+	for _, result := range results {
+		value := strconv.Itoa(result.Result)
+		if result.Label != "" {
+			value = result.Label
+		}
+		if result.Dropped {
+			value = "(" + value + ")"
+		}
+		values = append(values, value)
+	}
+	return strings.Join(values, " ")
+}
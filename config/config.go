@@ -1,11 +1,113 @@
 package config
 
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
 // Config contains all wuerfler config settings
 type Config struct {
-	Port           int    `default:"80"`
-	SecurePort     int    `default:"0"`
-	SecureHostname string `default:""`
-	CertDir        string `default:""`
-	FrontendDir    string `default:""`
-	Debug          bool
+	Port            int           `default:"80"`
+	SecurePort      int           `default:"0"`
+	SecureHostname  string        `default:""`
+	CertDir         string        `default:""`
+	FrontendDir     string        `default:""`
+	StateDir        string        `default:""`
+	ShutdownTimeout time.Duration `default:"15s"`
+	ReconnectDelay  time.Duration `default:"10s"`
+	// ArchiveRetention controls how long closed rooms can be viewed. 0 disables archiving
+	ArchiveRetention time.Duration `default:"24h"`
+	// MaxArchives limits the amount of kept archives. The oldest ones are removed first. 0 means unlimited
+	MaxArchives int `default:"1000"`
+	// AdminToken enables the admin API. It has to be sent as bearer token
+	AdminToken string `default:""`
+	Debug      bool
+
+	// CachedResults controls the amount of results per room that is sent upon reconnect
+	CachedResults int `default:"10"`
+	// ArchivedResults controls the amount of results kept for the archive of a room
+	ArchivedResults int `default:"1000"`
+	// RoomIdleTime determines when a room is being closed once all members left
+	RoomIdleTime time.Duration `default:"60s"`
+	// MaxMessageSize is the maximum websocket message size allowed from clients
+	MaxMessageSize int64 `default:"512"`
+	// PongWait is the time allowed to read the next pong message from a client
+	PongWait time.Duration `default:"60s"`
+	// PingPeriod is the interval pings are sent to clients. Must be less than PongWait
+	PingPeriod        time.Duration `default:"54s"`
+	MaxRoomNameLength int           `default:"1024"`
+	// MaxRooms limits the amount of concurrently open rooms. 0 means unlimited
+	MaxRooms int `default:"1000"`
+	// MaxRollersPerRoom limits the amount of rollers in one room. 0 means unlimited
+	MaxRollersPerRoom int `default:"50"`
+	MaxDicePerRoll    int `default:"50"`
+
+	// Rate limits are token buckets. Rates are per second, a rate of 0 disables the limit.
+	// Rolls and messages are limited per connection, connects and room creation per IP
+	RollRate        float64 `default:"2"`
+	RollBurst       int     `default:"10"`
+	MessageRate     float64 `default:"10"`
+	MessageBurst    int     `default:"30"`
+	ConnectRate     float64 `default:"1"`
+	ConnectBurst    int     `default:"10"`
+	RoomCreateRate  float64 `default:"0.1"`
+	RoomCreateBurst int     `default:"5"`
+}
+
+func validateRateLimit(name string, rate float64, burst int) error {
+	if rate < 0 {
+		return fmt.Errorf("%sRATE must not be negative", name)
+	}
+	if rate > 0 && burst < 1 {
+		return fmt.Errorf("%sBURST must be at least 1", name)
+	}
+	return nil
+}
+
+// Validate checks if the settings make sense
+func (c Config) Validate() error {
+	if c.CachedResults < 0 {
+		return errors.New("CACHEDRESULTS must not be negative")
+	}
+	if c.ArchivedResults < c.CachedResults {
+		return errors.New("ARCHIVEDRESULTS must be at least CACHEDRESULTS")
+	}
+	if c.RoomIdleTime <= 0 {
+		return errors.New("ROOMIDLETIME must be positive")
+	}
+	if c.MaxMessageSize <= 0 {
+		return errors.New("MAXMESSAGESIZE must be positive")
+	}
+	if c.PingPeriod <= 0 || c.PingPeriod >= c.PongWait {
+		return errors.New("PINGPERIOD must be positive and less than PONGWAIT")
+	}
+	if c.MaxRoomNameLength <= 0 {
+		return errors.New("MAXROOMNAMELENGTH must be positive")
+	}
+	if c.MaxRooms < 0 {
+		return errors.New("MAXROOMS must not be negative")
+	}
+	if c.MaxArchives < 0 {
+		return errors.New("MAXARCHIVES must not be negative")
+	}
+	if c.MaxRollersPerRoom < 0 {
+		return errors.New("MAXROLLERSPERROOM must not be negative")
+	}
+	if c.MaxDicePerRoll <= 0 {
+		return errors.New("MAXDICEPERROLL must be positive")
+	}
+	if err := validateRateLimit("ROLL", c.RollRate, c.RollBurst); err != nil {
+		return err
+	}
+	if err := validateRateLimit("MESSAGE", c.MessageRate, c.MessageBurst); err != nil {
+		return err
+	}
+	if err := validateRateLimit("CONNECT", c.ConnectRate, c.ConnectBurst); err != nil {
+		return err
+	}
+	if err := validateRateLimit("ROOMCREATE", c.RoomCreateRate, c.RoomCreateBurst); err != nil {
+		return err
+	}
+	return nil
 }
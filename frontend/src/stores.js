@@ -1,6 +1,35 @@
 import { writable } from "svelte/store";
 
-export const myself = writable("");
+export const myself = writable({ id: "", name: "" });
 export const friends = writable([]);
+export const owner = writable("");
+export const spectators = writable([]);
+export const npcs = writable([]);
+// the ID of whoever has the turn and the turn order managed by the GM
+export const turn = writable("");
+export const turnOrder = writable([]);
+export const settings = writable({
+  allowSpectators: true,
+  allowedDice: [],
+  dice: [],
+  features: []
+});
+// the builtin dice the owner may allow, the dice of the room and all expression features. sent by the server
+export const builtinDice = writable([]);
+export const roomDice = writable([]);
+export const features = writable([]);
+export const seeded = writable(false);
 export const rolls = writable([]);
+// unrevealed secret rolls. only the owner gets them
+export const secrets = writable([]);
+export const decks = writable([]);
+export const hand = writable({});
+export const tables = writable([]);
+export const macros = writable([]);
+export const trackers = writable([]);
+// timers remember when they have been received to keep running on their own
+export const timers = writable([]);
+export const probability = writable(null);
+export const rollCall = writable(null);
+export const contests = writable([]);
 export const alerts = writable([]);
@@ -17,12 +17,16 @@ func main() {
 	if err != nil {
 		log.Fatal(err.Error())
 	}
-	server := server.NewServer(conf)
-
+	err = conf.Validate()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
 	int := make(chan os.Signal, 1)
 	signal.Notify(int, os.Interrupt)
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() { <-int; cancel() }()
+
+	server := server.NewServer(ctx, conf)
 	err = server.Run(ctx)
 	if err != nil {
 		log.Fatal(err.Error())
@@ -0,0 +1,171 @@
+package rooms
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrRoomNotFound is returned when trying to access a room that isn't open
+	ErrRoomNotFound = errors.New("Room not found")
+	// ErrRollerNotFound is returned when a roller isn't part of the room
+	ErrRollerNotFound = errors.New("Roller not found")
+)
+
+// RoomSummary is an overview of an open room
+type RoomSummary struct {
+	Name         string    `json:"name"`
+	Members      int       `json:"members"`
+	Created      time.Time `json:"created"`
+	LastActivity time.Time `json:"lastActivity"`
+}
+
+// RollerDetails is everything an admin needs to know about a roller
+type RollerDetails struct {
+	UserInfo
+	IP     string    `json:"ip"`
+	Joined time.Time `json:"joined"`
+}
+
+// RoomDetails is the complete picture of an open room
+type RoomDetails struct {
+	RoomSummary
+	Rollers   []RollerDetails `json:"rollers"`
+	LastRolls []RollResults   `json:"lastRolls"`
+	Stats     RoomStats       `json:"stats"`
+}
+
+// inRoom executes f in the goroutine of the room and waits until it has finished
+func (m *Manager) inRoom(roomName string, f func(r *roomState)) error {
+	done := make(chan struct{})
+	op := func(r *roomState) {
+		defer close(done)
+		f(r)
+	}
+
+	room, err := m.lookupRoom(roomName)
+	if err != nil {
+		return ErrRoomNotFound
+	}
+	// see AddRoller
+	select {
+	case room.ops <- op:
+	case <-room.done:
+		return ErrRoomNotFound
+	}
+	// once sent an op will always be executed. even when the room is closing
+	<-done
+	return nil
+}
+
+func (r *roomState) summary() RoomSummary {
+	return RoomSummary{
+		Name:         r.name,
+		Members:      len(r.rollers),
+		Created:      r.created,
+		LastActivity: r.lastActivity,
+	}
+}
+
+// Rooms lists all open rooms
+func (m *Manager) Rooms() []RoomSummary {
+	roomNames := func() []string {
+		m.mutex.RLock()
+		defer m.mutex.RUnlock()
+		roomNames := make([]string, 0, len(m.rooms))
+		for k := range m.rooms {
+			roomNames = append(roomNames, k)
+		}
+		return roomNames
+	}()
+
+	summaries := make([]RoomSummary, 0, len(roomNames))
+	for _, roomName := range roomNames {
+		var summary RoomSummary
+		err := m.inRoom(roomName, func(r *roomState) {
+			summary = r.summary()
+		})
+		// closed in the meantime
+		if err != nil {
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// Inspect returns the details of an open room
+func (m *Manager) Inspect(roomName string) (RoomDetails, error) {
+	var details RoomDetails
+	err := m.inRoom(roomName, func(r *roomState) {
+		rollers := make([]RollerDetails, 0, len(r.rollers))
+		for _, roller := range r.rollers {
+			rollers = append(rollers, RollerDetails{
+				UserInfo: UserInfo{ID: roller.ID, Name: roller.Name},
+				IP:       roller.IP,
+				Joined:   roller.Joined,
+			})
+		}
+		lastRolls := make([]RollResults, len(r.lastRolls()))
+		copy(lastRolls, r.lastRolls())
+		details = RoomDetails{
+			RoomSummary: r.summary(),
+			Rollers:     rollers,
+			LastRolls:   lastRolls,
+			Stats:       r.stats,
+		}
+	})
+	return details, err
+}
+
+// Kick removes a roller from a room
+func (m *Manager) Kick(roomName string, rollerID string, message string) error {
+	if message == "" {
+		message = "You have been kicked by an admin"
+	}
+	found := false
+	err := m.inRoom(roomName, func(r *roomState) {
+		if findRoller(r.rollers, rollerID) < 0 {
+			return
+		}
+		found = true
+		r.log.Infof("Admin kicked %s", rollerID)
+		r.remove(rollerID, DisconnectReason{
+			Reason:  DisconnectKicked,
+			Message: message,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrRollerNotFound
+	}
+	return nil
+}
+
+// CloseRoom closes a room immediately. It will be archived like any other room
+func (m *Manager) CloseRoom(roomName string) error {
+	return m.inRoom(roomName, func(r *roomState) {
+		r.log.Info("Admin closed room")
+		r.end(DisconnectReason{
+			Reason:  DisconnectRoomClosed,
+			Message: "Room closed by an admin",
+		})
+	})
+}
+
+// Broadcast sends a notice to every roller in every room
+func (m *Manager) Broadcast(message string) {
+	notice := Notice{
+		Message: message,
+		Date:    time.Now(),
+	}
+	for _, summary := range m.Rooms() {
+		m.inRoom(summary.Name, func(r *roomState) {
+			for _, roller := range r.rollers {
+				r.sendEvent(roller, Event{Type: "notice", Payload: notice})
+			}
+		})
+	}
+}
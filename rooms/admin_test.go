@@ -0,0 +1,107 @@
+package rooms
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdmin(t *testing.T) {
+	m := newTestManager(t, testConfig(), nil)
+	name, err := m.CreateRoom("admin", DefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roller, err := m.AddRoller(name, JoinRequest{Name: "alice", IP: "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go drain(roller)
+
+	summaries := m.Rooms()
+	if len(summaries) != 1 || summaries[0].Name != name || summaries[0].Members != 1 {
+		t.Errorf("got rooms %+v", summaries)
+	}
+	details, err := m.Inspect(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(details.Rollers) != 1 || details.Rollers[0].ID != roller.ID || details.Rollers[0].IP != "192.0.2.1" {
+		t.Errorf("got rollers %+v", details.Rollers)
+	}
+	if _, err := m.Inspect("missing"); err != ErrRoomNotFound {
+		t.Errorf("inspecting a missing room: %v", err)
+	}
+
+	if err := m.Kick(name, "nobody", ""); err != ErrRollerNotFound {
+		t.Errorf("kicking nobody: %v", err)
+	}
+	if err := m.Kick(name, roller.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	<-roller.Done
+	if reason := roller.DisconnectReason(); reason.Reason != DisconnectKicked {
+		t.Errorf("got disconnect reason %s", reason.Reason)
+	}
+
+	if err := m.CloseRoom(name); err != nil {
+		t.Fatal(err)
+	}
+	if m.Exists(name) {
+		t.Error("the room is still open")
+	}
+	if _, ok := m.Archived(name); !ok {
+		t.Error("the room hasn't been archived")
+	}
+	if err := m.CloseRoom(name); err != ErrRoomNotFound {
+		t.Errorf("closing a closed room: %v", err)
+	}
+}
+
+func TestBroadcastToSlowRoller(t *testing.T) {
+	m := newTestManager(t, testConfig(), nil)
+	name, err := m.CreateRoom("broadcast", DefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stuck, err := m.AddRoller(name, JoinRequest{Name: "stuck"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := m.AddRoller(name, JoinRequest{Name: "listener"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notices := make(chan string, rollerBuffer)
+	go func() {
+		for {
+			select {
+			case event := <-listener.Events:
+				if event.Type == "notice" {
+					notices <- event.Payload.(Notice).Message
+				}
+			case <-listener.UsersUpdate:
+			case <-listener.RollResultsChan:
+			case <-listener.Done:
+				return
+			}
+		}
+	}()
+
+	// the stuck roller already got the events sent when joining so the notices overflow its buffer
+	within(t, 5*time.Second, "broadcasting", func() {
+		for i := 0; i < rollerBuffer; i++ {
+			m.Broadcast("hello")
+		}
+	})
+	<-stuck.Done
+	if reason := stuck.DisconnectReason(); reason.Reason != DisconnectTooSlow {
+		t.Errorf("got disconnect reason %s", reason.Reason)
+	}
+	for i := 0; i < rollerBuffer; i++ {
+		select {
+		case <-notices:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only got %d notices", i)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package rooms
+
+import (
+	"time"
+)
+
+// RoomStats contains some numbers about a room
+type RoomStats struct {
+	Rolls   int `json:"rolls"`
+	Dice    int `json:"dice"`
+	Members int `json:"members"`
+}
+
+// Archive is the read only record of a closed room
+type Archive struct {
+	Name    string        `json:"name"`
+	Created time.Time     `json:"created"`
+	Closed  time.Time     `json:"closed"`
+	Members []UserInfo    `json:"members"`
+	History []RollResults `json:"history"`
+	Stats   RoomStats     `json:"stats"`
+}
+
+func (r *roomState) archive() Archive {
+	return Archive{
+		Name:    r.name,
+		Created: r.created,
+		Closed:  time.Now(),
+		Members: r.members,
+		History: r.history,
+		Stats:   r.stats,
+	}
+}
+
+func (m *Manager) archive(archive Archive) {
+	if m.conf.ArchiveRetention <= 0 {
+		return
+	}
+	removed := m.addArchive(archive)
+	if m.storage == nil {
+		return
+	}
+	if err := m.storage.SaveArchive(archive); err != nil {
+		m.log.Errorf("Couldn't save archive of room `%s`: %v", archive.Name, err)
+	}
+	m.deleteArchives(removed)
+}
+
+// addArchive keeps an archive. If there are too many archives the oldest ones are removed and their names returned
+func (m *Manager) addArchive(archive Archive) []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.archives[archive.Name] = archive
+
+	var removed []string
+	for m.conf.MaxArchives > 0 && len(m.archives) > m.conf.MaxArchives {
+		oldest := ""
+		for name, archive := range m.archives {
+			if oldest == "" || archive.Closed.Before(m.archives[oldest].Closed) {
+				oldest = name
+			}
+		}
+		delete(m.archives, oldest)
+		removed = append(removed, oldest)
+		m.log.Infof("Removed archive of room `%s` to make room for new ones", oldest)
+	}
+	return removed
+}
+
+func (m *Manager) deleteArchives(names []string) {
+	if m.storage == nil {
+		return
+	}
+	for _, name := range names {
+		if err := m.storage.DeleteArchive(name); err != nil {
+			m.log.Errorf("Couldn't delete archive of room `%s`: %v", name, err)
+		}
+	}
+}
+
+// restoreArchives loads the stored archives which didn't expire yet
+func (m *Manager) restoreArchives() {
+	archives, err := m.storage.LoadArchives()
+	if err != nil {
+		m.log.Errorf("Couldn't load archives: %v", err)
+	}
+	var removed []string
+	for _, archive := range archives {
+		if m.conf.ArchiveRetention <= 0 || time.Since(archive.Closed) > m.conf.ArchiveRetention {
+			removed = append(removed, archive.Name)
+			continue
+		}
+		removed = append(removed, m.addArchive(archive)...)
+	}
+	m.deleteArchives(removed)
+}
+
+// Archived returns the archive of a closed room
+func (m *Manager) Archived(roomName string) (Archive, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	archive, ok := m.archives[roomName]
+	if !ok || time.Since(archive.Closed) > m.conf.ArchiveRetention {
+		return Archive{}, false
+	}
+	return archive, true
+}
+
+func (m *Manager) removeExpiredArchives() {
+	removed := func() []string {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		var removed []string
+		for name, archive := range m.archives {
+			if time.Since(archive.Closed) > m.conf.ArchiveRetention {
+				delete(m.archives, name)
+				removed = append(removed, name)
+				m.log.Infof("Removed archive of room `%s`", name)
+			}
+		}
+		return removed
+	}()
+	m.deleteArchives(removed)
+}
+
+func (m *Manager) runArchiveCleanup() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.removeExpiredArchives()
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
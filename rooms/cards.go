@@ -0,0 +1,343 @@
+package rooms
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+const (
+	// DeckStandard is a deck of 52 playing cards and 2 jokers
+	DeckStandard = "standard"
+	// DeckTarot are the 78 cards of the major and minor arcana
+	DeckTarot = "tarot"
+	// DeckCustom consists of cards named by the owner
+	DeckCustom = "custom"
+
+	maxDecks          = 10
+	maxCustomCards    = 200
+	maxCardNameLength = 64
+)
+
+// Card is a single card of a deck. Value orders cards of the standard deck (2 to Ace = 14, Joker = 15)
+type Card struct {
+	Name  string `json:"name"`
+	Suit  string `json:"suit,omitempty"`
+	Value int    `json:"value,omitempty"`
+}
+
+// Deck is the state of a deck of cards in a room
+type Deck struct {
+	Kind    string `json:"kind"`
+	Draw    []Card `json:"draw"`
+	Discard []Card `json:"discard"`
+	// Hands are the cards drawn by each roller
+	Hands map[string][]Card `json:"hands"`
+}
+
+// CreateDeckRequest creates or replaces a deck. Only the owner may create decks
+type CreateDeckRequest struct {
+	Deck string `json:"deck"`
+	Kind string `json:"kind"`
+	// Cards are the names of the cards of a custom deck
+	Cards []string `json:"cards"`
+}
+
+// ShuffleRequest shuffles the draw pile of a deck. Only the owner may shuffle
+type ShuffleRequest struct {
+	Deck string `json:"deck"`
+}
+
+// ReshuffleRequest puts the discard pile back into the deck and shuffles it. Only the owner may reshuffle.
+// All also collects the cards in the hands of the rollers
+type ReshuffleRequest struct {
+	Deck string `json:"deck"`
+	All  bool   `json:"all"`
+}
+
+// DrawRequest draws cards from a deck. Private cards are only shown to the roller drawing them
+type DrawRequest struct {
+	Deck    string `json:"deck"`
+	Count   int    `json:"count"`
+	Private bool   `json:"private"`
+}
+
+// DiscardRequest discards cards of the hand of a roller. All cards are discarded if none are given
+type DiscardRequest struct {
+	Deck  string   `json:"deck"`
+	Cards []string `json:"cards"`
+}
+
+// DeckInfo describes a deck without revealing its cards
+type DeckInfo struct {
+	Name      string         `json:"name"`
+	Kind      string         `json:"kind"`
+	Remaining int            `json:"remaining"`
+	Discarded int            `json:"discarded"`
+	TopCard   *Card          `json:"topCard"`
+	InHands   map[string]int `json:"inHands"`
+}
+
+// CardsInfo is broadcast whenever somebody does something with a deck. Cards are omitted for private draws
+type CardsInfo struct {
+	Action   string `json:"action"`
+	Deck     string `json:"deck"`
+	RollerID string `json:"rollerId"`
+	Name     string `json:"name"`
+	Count    int    `json:"count"`
+	Cards    []Card `json:"cards"`
+}
+
+func standardCards() []Card {
+	suits := []string{"♠", "♥", "♦", "♣"}
+	ranks := []string{"2", "3", "4", "5", "6", "7", "8", "9", "10", "J", "Q", "K", "A"}
+	cards := make([]Card, 0, 54)
+	for _, suit := range suits {
+		for i, rank := range ranks {
+			cards = append(cards, Card{Name: rank + suit, Suit: suit, Value: i + 2})
+		}
+	}
+	return append(cards, Card{Name: "Red Joker", Value: 15}, Card{Name: "Black Joker", Value: 15})
+}
+
+func tarotCards() []Card {
+	major := []string{
+		"The Fool", "The Magician", "The High Priestess", "The Empress", "The Emperor", "The Hierophant",
+		"The Lovers", "The Chariot", "Strength", "The Hermit", "Wheel of Fortune", "Justice", "The Hanged Man",
+		"Death", "Temperance", "The Devil", "The Tower", "The Star", "The Moon", "The Sun", "Judgement", "The World",
+	}
+	suits := []string{"Wands", "Cups", "Swords", "Pentacles"}
+	ranks := []string{"Ace", "Two", "Three", "Four", "Five", "Six", "Seven", "Eight", "Nine", "Ten", "Page", "Knight", "Queen", "King"}
+	cards := make([]Card, 0, 78)
+	for _, name := range major {
+		cards = append(cards, Card{Name: name})
+	}
+	for _, suit := range suits {
+		for _, rank := range ranks {
+			cards = append(cards, Card{Name: rank + " of " + suit, Suit: suit})
+		}
+	}
+	return cards
+}
+
+func newDeck(request CreateDeckRequest) (*Deck, error) {
+	if request.Deck == "" || len(request.Deck) > maxCardNameLength {
+		return nil, fmt.Errorf("Deck names must have between 1 and %d characters", maxCardNameLength)
+	}
+	deck := &Deck{
+		Kind:    request.Kind,
+		Discard: make([]Card, 0),
+		Hands:   make(map[string][]Card),
+	}
+	switch request.Kind {
+	case DeckStandard:
+		deck.Draw = standardCards()
+	case DeckTarot:
+		deck.Draw = tarotCards()
+	case DeckCustom:
+		if len(request.Cards) == 0 || len(request.Cards) > maxCustomCards {
+			return nil, fmt.Errorf("Custom decks must have between 1 and %d cards", maxCustomCards)
+		}
+		deck.Draw = make([]Card, 0, len(request.Cards))
+		for _, name := range request.Cards {
+			if name == "" || len(name) > maxCardNameLength {
+				return nil, fmt.Errorf("Card names must have between 1 and %d characters", maxCardNameLength)
+			}
+			deck.Draw = append(deck.Draw, Card{Name: name})
+		}
+	default:
+		return nil, fmt.Errorf("Unknown deck %s", request.Kind)
+	}
+	return deck, nil
+}
+
+func (r *roomState) shuffle(cards []Card) {
+	r.dice.use(func(rng *rand.Rand) *LogEntry {
+		rng.Shuffle(len(cards), func(i, j int) {
+			cards[i], cards[j] = cards[j], cards[i]
+		})
+		return &LogEntry{Type: LogShuffle, Cards: len(cards)}
+	})
+}
+
+func (r *roomState) deckInfos() []DeckInfo {
+	names := make([]string, 0, len(r.decks))
+	for name := range r.decks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	infos := make([]DeckInfo, 0, len(names))
+	for _, name := range names {
+		deck := r.decks[name]
+		info := DeckInfo{
+			Name:      name,
+			Kind:      deck.Kind,
+			Remaining: len(deck.Draw),
+			Discarded: len(deck.Discard),
+			InHands:   make(map[string]int),
+		}
+		if len(deck.Discard) > 0 {
+			info.TopCard = &deck.Discard[len(deck.Discard)-1]
+		}
+		for id, hand := range deck.Hands {
+			info.InHands[id] = len(hand)
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// hand are the cards of a roller in every deck
+func (r *roomState) hand(id string) map[string][]Card {
+	hand := make(map[string][]Card)
+	for name, deck := range r.decks {
+		if cards := deck.Hands[id]; len(cards) > 0 {
+			hand[name] = cards
+		}
+	}
+	return hand
+}
+
+// sendDecks tells everybody about the decks and their own hands
+func (r *roomState) sendDecks() {
+	infos := r.deckInfos()
+	for _, roller := range r.rollers {
+		r.sendEvent(roller, Event{Type: "decks", Payload: infos})
+		r.sendEvent(roller, Event{Type: "hand", Payload: r.hand(roller.ID)})
+	}
+}
+
+func (r *roomState) broadcastCards(from Roller, action string, deck string, count int, cards []Card) {
+	info := CardsInfo{
+		Action:   action,
+		Deck:     deck,
+		RollerID: from.ID,
+		Name:     from.Name,
+		Count:    count,
+		Cards:    cards,
+	}
+	for _, roller := range r.rollers {
+		r.sendEvent(roller, Event{Type: "cards", Payload: info})
+	}
+	r.sendDecks()
+}
+
+func (r *roomState) findDeck(from Roller, name string) *Deck {
+	deck, ok := r.decks[name]
+	if !ok {
+		r.sendError(from, fmt.Sprintf("Deck %s not found", name))
+	}
+	return deck
+}
+
+func (r *roomState) createDeck(from Roller, request CreateDeckRequest) {
+	if from.ID != r.owner {
+		r.sendError(from, "Only the owner may create decks")
+		return
+	}
+	if _, ok := r.decks[request.Deck]; !ok && len(r.decks) >= maxDecks {
+		r.sendError(from, fmt.Sprintf("A room can't have more than %d decks", maxDecks))
+		return
+	}
+	deck, err := newDeck(request)
+	if err != nil {
+		r.sendError(from, err.Error())
+		return
+	}
+	r.shuffle(deck.Draw)
+	r.decks[request.Deck] = deck
+	r.broadcastCards(from, "create", request.Deck, len(deck.Draw), nil)
+}
+
+func (r *roomState) shuffleDeck(from Roller, request ShuffleRequest) {
+	if from.ID != r.owner {
+		r.sendError(from, "Only the owner may shuffle")
+		return
+	}
+	deck := r.findDeck(from, request.Deck)
+	if deck == nil {
+		return
+	}
+	r.shuffle(deck.Draw)
+	r.broadcastCards(from, "shuffle", request.Deck, len(deck.Draw), nil)
+}
+
+func (r *roomState) reshuffleDeck(from Roller, request ReshuffleRequest) {
+	if from.ID != r.owner {
+		r.sendError(from, "Only the owner may reshuffle")
+		return
+	}
+	deck := r.findDeck(from, request.Deck)
+	if deck == nil {
+		return
+	}
+	deck.Draw = append(deck.Draw, deck.Discard...)
+	deck.Discard = make([]Card, 0)
+	if request.All {
+		for id, hand := range deck.Hands {
+			deck.Draw = append(deck.Draw, hand...)
+			delete(deck.Hands, id)
+		}
+	}
+	r.shuffle(deck.Draw)
+	r.broadcastCards(from, "reshuffle", request.Deck, len(deck.Draw), nil)
+}
+
+func (r *roomState) draw(from Roller, request DrawRequest) {
+	deck := r.findDeck(from, request.Deck)
+	if deck == nil {
+		return
+	}
+	if request.Count < 1 {
+		request.Count = 1
+	}
+	if request.Count > len(deck.Draw) {
+		r.sendError(from, fmt.Sprintf("Only %d cards left in %s", len(deck.Draw), request.Deck))
+		return
+	}
+	cards := make([]Card, request.Count)
+	copy(cards, deck.Draw[:request.Count])
+	deck.Draw = deck.Draw[request.Count:]
+	deck.Hands[from.ID] = append(deck.Hands[from.ID], cards...)
+
+	if request.Private {
+		r.broadcastCards(from, "drawPrivate", request.Deck, len(cards), nil)
+	} else {
+		r.broadcastCards(from, "draw", request.Deck, len(cards), cards)
+	}
+}
+
+func (r *roomState) discard(from Roller, request DiscardRequest) {
+	deck := r.findDeck(from, request.Deck)
+	if deck == nil {
+		return
+	}
+	hand := deck.Hands[from.ID]
+	discarded := make([]Card, 0, len(hand))
+	if len(request.Cards) == 0 {
+		discarded = append(discarded, hand...)
+		hand = nil
+	}
+	for _, name := range request.Cards {
+		found := false
+		for i, card := range hand {
+			if card.Name == name {
+				discarded = append(discarded, card)
+				hand = append(hand[:i:i], hand[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			r.sendError(from, fmt.Sprintf("%s is not in your hand", name))
+			return
+		}
+	}
+	if len(hand) == 0 {
+		delete(deck.Hands, from.ID)
+	} else {
+		deck.Hands[from.ID] = hand
+	}
+	deck.Discard = append(deck.Discard, discarded...)
+	r.broadcastCards(from, "discard", request.Deck, len(discarded), discarded)
+}
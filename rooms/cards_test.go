@@ -0,0 +1,127 @@
+package rooms
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewDeck(t *testing.T) {
+	tests := []struct {
+		name    string
+		request CreateDeckRequest
+		cards   int
+		wantErr bool
+	}{
+		{name: "standard", request: CreateDeckRequest{Deck: "poker", Kind: DeckStandard}, cards: 54},
+		{name: "tarot", request: CreateDeckRequest{Deck: "fate", Kind: DeckTarot}, cards: 78},
+		{name: "custom", request: CreateDeckRequest{Deck: "loot", Kind: DeckCustom, Cards: []string{"sword", "shield"}}, cards: 2},
+		{name: "no name", request: CreateDeckRequest{Kind: DeckStandard}, wantErr: true},
+		{name: "long name", request: CreateDeckRequest{Deck: strings.Repeat("a", maxCardNameLength+1), Kind: DeckStandard}, wantErr: true},
+		{name: "unknown kind", request: CreateDeckRequest{Deck: "uno", Kind: "uno"}, wantErr: true},
+		{name: "empty custom", request: CreateDeckRequest{Deck: "loot", Kind: DeckCustom}, wantErr: true},
+		{name: "too many cards", request: CreateDeckRequest{Deck: "loot", Kind: DeckCustom, Cards: make([]string, maxCustomCards+1)}, wantErr: true},
+		{name: "empty card", request: CreateDeckRequest{Deck: "loot", Kind: DeckCustom, Cards: []string{"sword", ""}}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deck, err := newDeck(test.request)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deck.Draw) != test.cards {
+				t.Errorf("got %d cards, want %d", len(deck.Draw), test.cards)
+			}
+			names := make(map[string]bool)
+			for _, card := range deck.Draw {
+				if names[card.Name] {
+					t.Errorf("%s is in the deck twice", card.Name)
+				}
+				names[card.Name] = true
+			}
+		})
+	}
+}
+
+// expectCards skips everything until somebody did the given action with a deck
+func expectCards(t *testing.T, roller Roller, action string) CardsInfo {
+	t.Helper()
+	for {
+		if info := expect(t, roller, "cards").Payload.(CardsInfo); info.Action == action {
+			return info
+		}
+	}
+}
+
+func TestDecks(t *testing.T) {
+	m := newTestManager(t, testConfig(), nil)
+	name, err := m.CreateRoom("cards", DefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := join(t, m, name, JoinRequest{Name: "owner"})
+	bob := join(t, m, name, JoinRequest{Name: "bob"})
+	carol := join(t, m, name, JoinRequest{Name: "carol"})
+
+	bob.Requests <- &CreateDeckRequest{Deck: "loot", Kind: DeckCustom, Cards: []string{"a"}}
+	if message := expect(t, bob, "error").Payload; message != "Only the owner may create decks" {
+		t.Errorf("got error %v", message)
+	}
+	owner.Requests <- &CreateDeckRequest{Deck: "loot", Kind: DeckCustom, Cards: []string{"sword", "shield", "potion"}}
+	if info := expectCards(t, bob, "create"); info.Count != 3 {
+		t.Errorf("got %+v", info)
+	}
+
+	// nobody else sees the cards of a private draw
+	bob.Requests <- &DrawRequest{Deck: "loot", Count: 2, Private: true}
+	if info := expectCards(t, carol, "drawPrivate"); info.Count != 2 || info.Cards != nil {
+		t.Errorf("got %+v", info)
+	}
+	expectCards(t, bob, "drawPrivate")
+	hand := expect(t, bob, "hand").Payload.(map[string][]Card)["loot"]
+	if len(hand) != 2 {
+		t.Fatalf("got hand %+v", hand)
+	}
+
+	bob.Requests <- &DrawRequest{Deck: "loot", Count: 2}
+	if message := expect(t, bob, "error").Payload; message != "Only 1 cards left in loot" {
+		t.Errorf("got error %v", message)
+	}
+	bob.Requests <- &DrawRequest{Deck: "missing"}
+	if message := expect(t, bob, "error").Payload; message != "Deck missing not found" {
+		t.Errorf("got error %v", message)
+	}
+	bob.Requests <- &DiscardRequest{Deck: "loot", Cards: []string{"crown"}}
+	if message := expect(t, bob, "error").Payload; message != "crown is not in your hand" {
+		t.Errorf("got error %v", message)
+	}
+
+	bob.Requests <- &DiscardRequest{Deck: "loot", Cards: []string{hand[0].Name}}
+	info := expectCards(t, carol, "discard")
+	if len(info.Cards) != 1 || info.Cards[0] != hand[0] {
+		t.Errorf("got %+v", info)
+	}
+	decks := expect(t, carol, "decks").Payload.([]DeckInfo)
+	if len(decks) != 1 || decks[0].Remaining != 1 || decks[0].Discarded != 1 || decks[0].InHands[bob.ID] != 1 ||
+		*decks[0].TopCard != hand[0] {
+		t.Errorf("got %+v", decks)
+	}
+
+	bob.Requests <- &ReshuffleRequest{Deck: "loot"}
+	if message := expect(t, bob, "error").Payload; message != "Only the owner may reshuffle" {
+		t.Errorf("got error %v", message)
+	}
+	owner.Requests <- &ReshuffleRequest{Deck: "loot", All: true}
+	if info := expectCards(t, carol, "reshuffle"); info.Count != 3 {
+		t.Errorf("got %+v", info)
+	}
+	expectCards(t, bob, "reshuffle")
+	if hand := expect(t, bob, "hand").Payload.(map[string][]Card); len(hand) != 0 {
+		t.Errorf("the hand hasn't been collected: %+v", hand)
+	}
+}
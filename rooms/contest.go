@@ -0,0 +1,229 @@
+package rooms
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// TieDraw lets contests end without winner on a tie
+	TieDraw = "draw"
+	// TieChallenger lets the challenger win ties
+	TieChallenger = "challenger"
+	// TieOpponent lets the challenged roller win ties
+	TieOpponent = "opponent"
+	// TieReroll rolls again until there is a winner
+	TieReroll = "reroll"
+
+	contestTimeout = 2 * time.Minute
+	maxContests    = 20
+	maxRerolls     = 10
+)
+
+// ChallengeRequest challenges another roller to a contest. Both roll their own expression.
+// Tie overrides the tie rule of the room
+type ChallengeRequest struct {
+	Opponent           string `json:"opponent"`
+	Title              string `json:"title"`
+	Expression         string `json:"expression"`
+	OpponentExpression string `json:"opponentExpression"`
+	Tie                string `json:"tie"`
+}
+
+// ContestRollRequest rolls the expression of a roller in a contest
+type ContestRollRequest struct {
+	ID    string `json:"id"`
+	Mode  string `json:"mode"`
+	Times int    `json:"times"`
+}
+
+// Contestant is one side of a contest. Total is only set after rolling
+type Contestant struct {
+	UserInfo
+	Expression string `json:"expression"`
+	Rolled     bool   `json:"rolled"`
+	Total      int    `json:"total"`
+}
+
+// ContestInfo is broadcast when a contest starts and ends. Winner is the ID of the winner or empty on a draw
+type ContestInfo struct {
+	ID         string     `json:"id"`
+	Title      string     `json:"title"`
+	Status     string     `json:"status"`
+	Tie        string     `json:"tie"`
+	Challenger Contestant `json:"challenger"`
+	Opponent   Contestant `json:"opponent"`
+	Winner     string     `json:"winner"`
+	Rerolls    int        `json:"rerolls"`
+}
+
+type contest struct {
+	ContestInfo
+	timer *time.Timer
+}
+
+func validTieRule(tie string) bool {
+	switch tie {
+	case TieDraw, TieChallenger, TieOpponent, TieReroll:
+		return true
+	}
+	return false
+}
+
+func (r *roomState) broadcastContest(c *contest) {
+	for _, roller := range r.rollers {
+		r.sendEvent(roller, Event{Type: "contest", Payload: c.ContestInfo})
+	}
+}
+
+func (r *roomState) challenge(from Roller, request ChallengeRequest) {
+	if err := r.mayRoll(from); err != nil {
+		r.sendError(from, err.Error())
+		return
+	}
+	if len(r.contests) >= maxContests {
+		r.sendError(from, "Too many running contests")
+		return
+	}
+	if request.Opponent == from.ID {
+		r.sendError(from, "You can't challenge yourself")
+		return
+	}
+	i := findRoller(r.rollers, request.Opponent)
+	if i < 0 || r.rollers[i].Spectator {
+		r.sendError(from, "Roller not found")
+		return
+	}
+	if request.OpponentExpression == "" {
+		request.OpponentExpression = request.Expression
+	}
+	settings := r.settings.get()
+	if request.Tie == "" {
+		request.Tie = settings.TieRule
+	}
+	if !validTieRule(request.Tie) {
+		r.sendError(from, fmt.Sprintf("Unknown tie rule %s", request.Tie))
+		return
+	}
+	system, err := lookupSystem(settings.System)
+	for _, expression := range []string{request.Expression, request.OpponentExpression} {
+		if err == nil {
+			_, err = system.ParseRoll(RollRequest{Expression: expression}, settings)
+		}
+	}
+	if err != nil {
+		r.sendError(from, err.Error())
+		return
+	}
+
+	c := &contest{
+		ContestInfo: ContestInfo{
+			ID:     newID(),
+			Title:  request.Title,
+			Status: "pending",
+			Tie:    request.Tie,
+			Challenger: Contestant{
+				UserInfo:   UserInfo{ID: from.ID, Name: from.Name},
+				Expression: request.Expression,
+			},
+			Opponent: Contestant{
+				UserInfo:   UserInfo{ID: r.rollers[i].ID, Name: r.rollers[i].Name},
+				Expression: request.OpponentExpression,
+			},
+		},
+	}
+	id := c.ID
+	c.timer = r.after(contestTimeout, func(r *roomState) {
+		c, ok := r.contests[id]
+		if !ok {
+			return
+		}
+		delete(r.contests, id)
+		c.Status = "expired"
+		r.broadcastContest(c)
+	})
+	r.contests[id] = c
+	r.broadcastContest(c)
+}
+
+func (r *roomState) rollContest(from Roller, request ContestRollRequest) {
+	c, ok := r.contests[request.ID]
+	if !ok {
+		r.sendError(from, "The contest is over")
+		return
+	}
+	var contestant *Contestant
+	switch from.ID {
+	case c.Challenger.ID:
+		contestant = &c.Challenger
+	case c.Opponent.ID:
+		contestant = &c.Opponent
+	default:
+		r.sendError(from, "You are not part of this contest")
+		return
+	}
+	if contestant.Rolled {
+		r.sendError(from, "You have already rolled")
+		return
+	}
+	// the opponent has been called on to roll so only the challenger has to wait for their turn
+	if contestant == &c.Challenger {
+		if err := r.mayRoll(from); err != nil {
+			r.sendError(from, err.Error())
+			return
+		}
+	}
+	roll, err := r.contestRoll(from, c, contestant.Expression, request.Mode, request.Times)
+	if err != nil {
+		r.sendError(from, err.Error())
+		return
+	}
+	contestant.Rolled = true
+	contestant.Total = roll.Total
+	if !c.Challenger.Rolled || !c.Opponent.Rolled {
+		return
+	}
+
+	c.timer.Stop()
+	delete(r.contests, c.ID)
+	for c.Challenger.Total == c.Opponent.Total && c.Tie == TieReroll && c.Rerolls < maxRerolls {
+		c.Rerolls++
+		for _, contestant := range []*Contestant{&c.Challenger, &c.Opponent} {
+			i := findRoller(r.rollers, contestant.ID)
+			if i < 0 {
+				continue
+			}
+			roll, err := r.contestRoll(r.rollers[i], c, contestant.Expression, "", 0)
+			if err == nil {
+				contestant.Total = roll.Total
+			}
+		}
+	}
+	switch {
+	case c.Challenger.Total > c.Opponent.Total:
+		c.Winner = c.Challenger.ID
+	case c.Challenger.Total < c.Opponent.Total:
+		c.Winner = c.Opponent.ID
+	case c.Tie == TieChallenger:
+		c.Winner = c.Challenger.ID
+	case c.Tie == TieOpponent:
+		c.Winner = c.Opponent.ID
+	}
+	c.Status = "done"
+	r.broadcastContest(c)
+}
+
+// contestRoll rolls for one side of a contest and shows it in the roll log
+func (r *roomState) contestRoll(roller Roller, c *contest, expression string, mode string, times int) (RollResults, error) {
+	roll, err := r.resolveRoll(roller, RollRequest{Expression: expression, Mode: mode, Times: times})
+	if err != nil {
+		return roll, err
+	}
+	roll.Name = roller.Name
+	roll.Contest = c.ID
+	r.addToHistory(&roll)
+	for _, roller := range r.rollers {
+		r.sendRoll(roller, roll)
+	}
+	return roll, nil
+}
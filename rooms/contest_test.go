@@ -0,0 +1,104 @@
+package rooms
+
+import "testing"
+
+// expectContest skips everything until a contest has the given status
+func expectContest(t *testing.T, roller Roller, status string) ContestInfo {
+	t.Helper()
+	for {
+		if info := expect(t, roller, "contest").Payload.(ContestInfo); info.Status == status {
+			return info
+		}
+	}
+}
+
+func TestContest(t *testing.T) {
+	settings := testSettings()
+	// always rolls 1 so the totals are known
+	settings.Dice = append(settings.Dice, Die{Name: "one", Faces: []Face{{Value: 1}}})
+	tests := []struct {
+		name                   string
+		expression, opponent   string
+		tie                    string
+		winner                 string
+		challenger, challenged int
+		rerolls                int
+	}{
+		{name: "challenger wins", expression: "d[one]+3", opponent: "d[one]+1", winner: "challenger", challenger: 4, challenged: 2},
+		{name: "opponent wins", expression: "d[one]", opponent: "2d[one]", winner: "opponent", challenger: 1, challenged: 2},
+		{name: "draw", expression: "d[one]", tie: TieDraw, challenger: 1, challenged: 1},
+		{name: "tie to challenger", expression: "d[one]", tie: TieChallenger, winner: "challenger", challenger: 1, challenged: 1},
+		{name: "tie to opponent", expression: "d[one]", tie: TieOpponent, winner: "opponent", challenger: 1, challenged: 1},
+		{name: "rerolls give up", expression: "d[one]", tie: TieReroll, challenger: 1, challenged: 1, rerolls: maxRerolls},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newTestManager(t, testConfig(), nil)
+			name, err := m.CreateRoom("contest", settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			alice := join(t, m, name, JoinRequest{Name: "alice"})
+			bob := join(t, m, name, JoinRequest{Name: "bob"})
+
+			alice.Requests <- &ChallengeRequest{Opponent: bob.ID, Expression: test.expression, OpponentExpression: test.opponent, Tie: test.tie}
+			c := expectContest(t, bob, "pending")
+			alice.Requests <- &ContestRollRequest{ID: c.ID}
+			bob.Requests <- &ContestRollRequest{ID: c.ID}
+			c = expectContest(t, alice, "done")
+
+			winners := map[string]string{"challenger": alice.ID, "opponent": bob.ID}
+			if c.Winner != winners[test.winner] || c.Challenger.Total != test.challenger || c.Opponent.Total != test.challenged ||
+				c.Rerolls != test.rerolls {
+				t.Errorf("got %+v", c)
+			}
+		})
+	}
+}
+
+func TestContestErrors(t *testing.T) {
+	m := newTestManager(t, testConfig(), nil)
+	name, err := m.CreateRoom("contest", DefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := join(t, m, name, JoinRequest{Name: "alice"})
+	bob := join(t, m, name, JoinRequest{Name: "bob"})
+	carol := join(t, m, name, JoinRequest{Name: "carol"})
+	spectator := join(t, m, name, JoinRequest{Name: "watcher", Spectator: true})
+
+	for _, test := range []struct {
+		request ChallengeRequest
+		message string
+	}{
+		{request: ChallengeRequest{Opponent: alice.ID, Expression: "d20"}, message: "You can't challenge yourself"},
+		{request: ChallengeRequest{Opponent: "nobody", Expression: "d20"}, message: "Roller not found"},
+		{request: ChallengeRequest{Opponent: spectator.ID, Expression: "d20"}, message: "Roller not found"},
+		{request: ChallengeRequest{Opponent: bob.ID, Expression: "d20", Tie: "coin"}, message: "Unknown tie rule coin"},
+	} {
+		request := test.request
+		alice.Requests <- &request
+		if message := expect(t, alice, "error").Payload; message != test.message {
+			t.Errorf("got error %v, want %s", message, test.message)
+		}
+	}
+	alice.Requests <- &ChallengeRequest{Opponent: bob.ID, Expression: "d20+"}
+	expect(t, alice, "error")
+
+	alice.Requests <- &ChallengeRequest{Opponent: bob.ID, Expression: "d20"}
+	c := expectContest(t, bob, "pending")
+	carol.Requests <- &ContestRollRequest{ID: c.ID}
+	if message := expect(t, carol, "error").Payload; message != "You are not part of this contest" {
+		t.Errorf("got error %v", message)
+	}
+	bob.Requests <- &ContestRollRequest{ID: c.ID}
+	expectRoll(t, bob)
+	bob.Requests <- &ContestRollRequest{ID: c.ID}
+	if message := expect(t, bob, "error").Payload; message != "You have already rolled" {
+		t.Errorf("got error %v", message)
+	}
+	bob.Requests <- &ContestRollRequest{ID: "over"}
+	if message := expect(t, bob, "error").Payload; message != "The contest is over" {
+		t.Errorf("got error %v", message)
+	}
+}
@@ -0,0 +1,166 @@
+package rooms
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxSides is the maximum number of sides of a numbered die (d2 to d1000000)
+	MaxSides = 1000000
+	// MaxFaces is the maximum number of faces of a die with explicit faces
+	MaxFaces = 100
+	// maxDieNameLength limits names of custom dice
+	maxDieNameLength = 32
+	// maxLabelLength limits the labels of faces
+	maxLabelLength = 32
+	// maxCustomDice limits the custom dice of a room as well as the dice with their own criticals
+	maxCustomDice = 20
+	// maxAllowedDice limits the builtin dice a room may restrict itself to
+	maxAllowedDice = 50
+)
+
+// Face is one side of a die. If set the label is shown instead of the value
+type Face struct {
+	Value int    `json:"value"`
+	Label string `json:"label,omitempty"`
+}
+
+// Die describes a die which can be rolled. Dice without explicit faces are numbered from 1 to Sides
+type Die struct {
+	Name      string     `json:"name"`
+	Sides     int        `json:"sides,omitempty"`
+	Faces     []Face     `json:"faces,omitempty"`
+	Criticals *Criticals `json:"criticals,omitempty"`
+}
+
+// Criticals are the values of a die which are a critical success or failure
+type Criticals struct {
+	Success []int `json:"success"`
+	Failure []int `json:"failure"`
+}
+
+// FudgeDie is the die used by Fudge and Fate
+var FudgeDie = Die{
+	Name: "dF",
+	Faces: []Face{
+		{Value: -1, Label: "-"},
+		{Value: 0, Label: "0"},
+		{Value: 1, Label: "+"},
+	},
+}
+
+// numberedDie parses dice like d6 or d1000
+func numberedDie(name string) (Die, bool) {
+	if !strings.HasPrefix(name, "d") {
+		return Die{}, false
+	}
+	sides, err := strconv.Atoi(name[1:])
+	if err != nil || sides < 2 || sides > MaxSides || fmt.Sprintf("d%d", sides) != name {
+		return Die{}, false
+	}
+	return Die{Name: name, Sides: sides}, true
+}
+
+// builtinDice are the usual builtin dice. Any other numbered die up to MaxSides is a builtin die as well
+var builtinDice = []string{"d4", "d6", "d8", "d10", "d12", "d20", "d100", FudgeDie.Name}
+
+// builtinDie finds a builtin die by name
+func builtinDie(name string) (Die, error) {
+	if name == FudgeDie.Name {
+		return FudgeDie, nil
+	}
+	if die, ok := numberedDie(name); ok {
+		return die, nil
+	}
+	return Die{}, fmt.Errorf("Unknown die %s", name)
+}
+
+// Validate checks a custom die
+func (d Die) Validate() error {
+	if d.Name == "" || len(d.Name) > maxDieNameLength {
+		return fmt.Errorf("Die names must have between 1 and %d characters", maxDieNameLength)
+	}
+	if len(d.Faces) > 0 {
+		if d.Sides != 0 {
+			return fmt.Errorf("Die %s has both sides and faces", d.Name)
+		}
+		if len(d.Faces) > MaxFaces {
+			return fmt.Errorf("Die %s has more than %d faces", d.Name, MaxFaces)
+		}
+		for _, face := range d.Faces {
+			if len(face.Label) > maxLabelLength {
+				return fmt.Errorf("Labels must not be longer than %d characters", maxLabelLength)
+			}
+		}
+	} else if d.Sides < 2 || d.Sides > MaxSides {
+		return fmt.Errorf("Die %s must have between 2 and %d sides", d.Name, MaxSides)
+	}
+	if d.Criticals != nil {
+		return d.Criticals.Validate()
+	}
+	return nil
+}
+
+// Validate checks the criticals
+func (c Criticals) Validate() error {
+	if len(c.Success) > MaxFaces || len(c.Failure) > MaxFaces {
+		return fmt.Errorf("Dice can't have more than %d critical results", MaxFaces)
+	}
+	return nil
+}
+
+func contains(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// mark flags a result as critical success or failure
+func (c *Criticals) mark(result *RollResult) {
+	switch {
+	case c == nil || result.Dropped:
+	case contains(c.Success, result.Result):
+		result.Critical = "success"
+	case contains(c.Failure, result.Result):
+		result.Critical = "failure"
+	}
+}
+
+func (d Die) roll(rng *rand.Rand) RollResult {
+	if len(d.Faces) > 0 {
+		face := d.Faces[rng.Intn(len(d.Faces))]
+		return RollResult{
+			Dice:   d.Name,
+			Result: face.Value,
+			Label:  face.Label,
+		}
+	}
+	return RollResult{
+		Dice:   d.Name,
+		Result: 1 + rng.Intn(d.Sides),
+	}
+}
+
+func validateDice(dice []Die) error {
+	if len(dice) > maxCustomDice {
+		return fmt.Errorf("A room can't have more than %d custom dice", maxCustomDice)
+	}
+	names := make(map[string]bool, len(dice))
+	for _, die := range dice {
+		if err := die.Validate(); err != nil {
+			return err
+		}
+		if names[die.Name] {
+			return errors.New("Die names must be unique")
+		}
+		names[die.Name] = true
+	}
+	return nil
+}
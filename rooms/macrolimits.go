@@ -0,0 +1,555 @@
+package rooms
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Starlark limits the steps of a macro but not its memory. A single step like 'a' * 400000000 allocates gigabytes.
+// Macros are rewritten so every operation which may allocate a lot at once goes through a builtin checking the size
+// of the result first and charging it to the budget of the run. Everything else allocates little per step
+
+const (
+	// maxMacroValueSize limits the characters of a string and the elements of a list created in a single step
+	maxMacroValueSize = 1 << 18
+	// maxMacroAllocation limits the characters and elements created by all checked operations of a run
+	maxMacroAllocation = 1 << 22
+	// maxMacroIntBits limits the size of integers
+	maxMacroIntBits = 4096
+)
+
+var errMacroMemory = errors.New("Macro uses too much memory")
+
+// macroOps are the operators which are checked. Augmented assignments are turned into plain ones
+var macroOps = map[syntax.Token]string{
+	syntax.PLUS:       "+",
+	syntax.STAR:       "*",
+	syntax.PERCENT:    "%",
+	syntax.LTLT:       "<<",
+	syntax.PLUS_EQ:    "+=",
+	syntax.STAR_EQ:    "*",
+	syntax.PERCENT_EQ: "%",
+	syntax.LTLT_EQ:    "<<",
+}
+
+var macroOpTokens = map[string]syntax.Token{
+	"+":  syntax.PLUS,
+	"+=": syntax.PLUS,
+	"*":  syntax.STAR,
+	"%":  syntax.PERCENT,
+	"<<": syntax.LTLT,
+}
+
+// macroInternals are the names of the checked builtins the rewritten macro calls. Macros may reassign globals so
+// they must not use names with this prefix. Otherwise __binary = 1 would replace the check
+var macroInternals = map[string]bool{"__binary": true, "__attr": true, "__charge": true}
+
+const macroInternalPrefix = "__"
+
+// compileMacro parses, rewrites and compiles a macro
+func compileMacro(name string, source string) (*starlark.Program, error) {
+	f, err := syntax.Parse(name, source, 0)
+	if err != nil {
+		return nil, err
+	}
+	var reserved *syntax.Ident
+	syntax.Walk(f, func(n syntax.Node) bool {
+		if ident, ok := n.(*syntax.Ident); ok && reserved == nil && strings.HasPrefix(ident.Name, macroInternalPrefix) {
+			reserved = ident
+		}
+		return reserved == nil
+	})
+	if reserved != nil {
+		return nil, fmt.Errorf("%s: names starting with %s are reserved", reserved.NamePos, macroInternalPrefix)
+	}
+	for _, stmt := range f.Stmts {
+		rewriteStmt(stmt)
+	}
+	return starlark.FileProgram(f, isMacroPredeclared)
+}
+
+// isMacroPredeclared reports the names a macro gets from the room. The universal builtins are replaced by checked ones
+func isMacroPredeclared(name string) bool {
+	if _, ok := starlark.Universe[name].(*starlark.Builtin); ok {
+		return true
+	}
+	return macroPredeclared[name] || macroInternals[name]
+}
+
+func macroCall(pos syntax.Position, name string, args ...syntax.Expr) syntax.Expr {
+	return &syntax.CallExpr{Fn: &syntax.Ident{NamePos: pos, Name: name}, Lparen: pos, Args: args, Rparen: pos}
+}
+
+func macroString(pos syntax.Position, s string) syntax.Expr {
+	return &syntax.Literal{Token: syntax.STRING, TokenPos: pos, Raw: strconv.Quote(s), Value: s}
+}
+
+func rewriteStmts(stmts []syntax.Stmt) {
+	for _, stmt := range stmts {
+		rewriteStmt(stmt)
+	}
+}
+
+func rewriteStmt(stmt syntax.Stmt) {
+	switch stmt := stmt.(type) {
+	case *syntax.AssignStmt:
+		stmt.LHS = rewriteTarget(stmt.LHS)
+		stmt.RHS = rewriteExpr(stmt.RHS)
+		if op, ok := macroOps[stmt.Op]; ok {
+			// x += y becomes x = __binary("+=", x, y). The target is evaluated twice which is fine for macros
+			value := stmt.LHS
+			if ident, ok := value.(*syntax.Ident); ok {
+				value = &syntax.Ident{NamePos: ident.NamePos, Name: ident.Name}
+			}
+			stmt.RHS = macroCall(stmt.OpPos, "__binary", macroString(stmt.OpPos, op), value, stmt.RHS)
+			stmt.Op = syntax.EQ
+		}
+	case *syntax.DefStmt:
+		rewriteParams(stmt.Params)
+		rewriteStmts(stmt.Body)
+	case *syntax.ExprStmt:
+		stmt.X = rewriteExpr(stmt.X)
+	case *syntax.IfStmt:
+		stmt.Cond = rewriteExpr(stmt.Cond)
+		rewriteStmts(stmt.True)
+		rewriteStmts(stmt.False)
+	case *syntax.ForStmt:
+		stmt.Vars = rewriteTarget(stmt.Vars)
+		stmt.X = rewriteExpr(stmt.X)
+		rewriteStmts(stmt.Body)
+	case *syntax.WhileStmt:
+		stmt.Cond = rewriteExpr(stmt.Cond)
+		rewriteStmts(stmt.Body)
+	case *syntax.ReturnStmt:
+		if stmt.Result != nil {
+			stmt.Result = rewriteExpr(stmt.Result)
+		}
+	}
+}
+
+// rewriteTarget rewrites the expressions within the target of an assignment but not the target itself
+func rewriteTarget(e syntax.Expr) syntax.Expr {
+	switch e := e.(type) {
+	case *syntax.IndexExpr:
+		e.X = rewriteExpr(e.X)
+		e.Y = rewriteExpr(e.Y)
+	case *syntax.DotExpr:
+		e.X = rewriteExpr(e.X)
+	case *syntax.ParenExpr:
+		e.X = rewriteTarget(e.X)
+	case *syntax.ListExpr:
+		for i := range e.List {
+			e.List[i] = rewriteTarget(e.List[i])
+		}
+	case *syntax.TupleExpr:
+		for i := range e.List {
+			e.List[i] = rewriteTarget(e.List[i])
+		}
+	}
+	return e
+}
+
+// rewriteParams rewrites the default values of parameters
+func rewriteParams(params []syntax.Expr) {
+	for _, param := range params {
+		if param, ok := param.(*syntax.BinaryExpr); ok && param.Op == syntax.EQ {
+			param.Y = rewriteExpr(param.Y)
+		}
+	}
+}
+
+func rewriteExprs(exprs []syntax.Expr) {
+	for i := range exprs {
+		exprs[i] = rewriteExpr(exprs[i])
+	}
+}
+
+func rewriteExpr(e syntax.Expr) syntax.Expr {
+	switch e := e.(type) {
+	case *syntax.ParenExpr:
+		e.X = rewriteExpr(e.X)
+	case *syntax.CallExpr:
+		e.Fn = rewriteExpr(e.Fn)
+		for i, arg := range e.Args {
+			switch arg := arg.(type) {
+			case *syntax.BinaryExpr:
+				if arg.Op == syntax.EQ {
+					// keyword argument
+					arg.Y = rewriteExpr(arg.Y)
+					continue
+				}
+			case *syntax.UnaryExpr:
+				if arg.Op == syntax.STAR || arg.Op == syntax.STARSTAR {
+					arg.X = rewriteExpr(arg.X)
+					continue
+				}
+			}
+			e.Args[i] = rewriteExpr(arg)
+		}
+	case *syntax.DotExpr:
+		return macroCall(e.Dot, "__attr", rewriteExpr(e.X), macroString(e.NamePos, e.Name.Name))
+	case *syntax.Comprehension:
+		e.Body = rewriteExpr(e.Body)
+		for _, clause := range e.Clauses {
+			switch clause := clause.(type) {
+			case *syntax.ForClause:
+				clause.Vars = rewriteTarget(clause.Vars)
+				clause.X = rewriteExpr(clause.X)
+			case *syntax.IfClause:
+				clause.Cond = rewriteExpr(clause.Cond)
+			}
+		}
+	case *syntax.DictExpr:
+		for _, entry := range e.List {
+			if entry, ok := entry.(*syntax.DictEntry); ok {
+				entry.Key = rewriteExpr(entry.Key)
+				entry.Value = rewriteExpr(entry.Value)
+			}
+		}
+	case *syntax.LambdaExpr:
+		rewriteParams(e.Params)
+		e.Body = rewriteExpr(e.Body)
+	case *syntax.ListExpr:
+		rewriteExprs(e.List)
+	case *syntax.TupleExpr:
+		rewriteExprs(e.List)
+	case *syntax.CondExpr:
+		e.Cond = rewriteExpr(e.Cond)
+		e.True = rewriteExpr(e.True)
+		e.False = rewriteExpr(e.False)
+	case *syntax.UnaryExpr:
+		if e.X != nil {
+			e.X = rewriteExpr(e.X)
+		}
+	case *syntax.BinaryExpr:
+		e.X = rewriteExpr(e.X)
+		e.Y = rewriteExpr(e.Y)
+		if op, ok := macroOps[e.Op]; ok {
+			return macroCall(e.OpPos, "__binary", macroString(e.OpPos, op), e.X, e.Y)
+		}
+	case *syntax.SliceExpr:
+		e.X = rewriteExpr(e.X)
+		for _, part := range []*syntax.Expr{&e.Lo, &e.Hi, &e.Step} {
+			if *part != nil {
+				*part = rewriteExpr(*part)
+			}
+		}
+		return macroCall(e.Lbrack, "__charge", e)
+	case *syntax.IndexExpr:
+		e.X = rewriteExpr(e.X)
+		e.Y = rewriteExpr(e.Y)
+	}
+	return e
+}
+
+// macroBudget tracks how much a single run of a macro allocated
+type macroBudget struct {
+	allocated int
+}
+
+func (b *macroBudget) charge(size int) error {
+	if size > maxMacroValueSize {
+		return errMacroMemory
+	}
+	b.allocated += size
+	if b.allocated > maxMacroAllocation {
+		return errMacroMemory
+	}
+	return nil
+}
+
+// chargeLen charges the length of strings and collections
+func (b *macroBudget) chargeLen(v starlark.Value) error {
+	if n := starlark.Len(v); n > 0 {
+		return b.charge(n)
+	}
+	return nil
+}
+
+// macroSize estimates the length of the string representation of a value. Lists may contain the same long string
+// many times so it may be far larger than the memory the value uses. It stops counting once limit is exceeded
+func macroSize(v starlark.Value, limit int) int {
+	size := 0
+	var visit func(v starlark.Value)
+	visit = func(v starlark.Value) {
+		if size > limit {
+			return
+		}
+		switch v := v.(type) {
+		case starlark.String:
+			size += len(v) + 2
+		case starlark.Int:
+			size += v.BigInt().BitLen()/3 + 1
+		case *starlark.Dict:
+			for _, item := range v.Items() {
+				size += 4
+				visit(item[0])
+				visit(item[1])
+				if size > limit {
+					return
+				}
+			}
+		case starlark.Iterable:
+			iter := v.Iterate()
+			defer iter.Done()
+			var x starlark.Value
+			for size <= limit && iter.Next(&x) {
+				size += 2
+				visit(x)
+			}
+		case starlark.HasAttrs:
+			for _, name := range v.AttrNames() {
+				size += len(name) + 3
+				if attr, err := v.Attr(name); err == nil && attr != nil {
+					visit(attr)
+				}
+			}
+		default:
+			size += 32
+		}
+	}
+	visit(v)
+	return size
+}
+
+func (b *macroBudget) checkSize(values ...starlark.Value) error {
+	size := 0
+	for _, v := range values {
+		size += macroSize(v, maxMacroValueSize)
+		if size > maxMacroValueSize {
+			return errMacroMemory
+		}
+	}
+	return b.charge(size)
+}
+
+// binarySize is the size of the result of a checked operator
+func binarySize(op syntax.Token, x, y starlark.Value) (int, error) {
+	switch op {
+	case syntax.PLUS:
+		if lx, ly := starlark.Len(x), starlark.Len(y); lx >= 0 && ly >= 0 {
+			return lx + ly, nil
+		}
+	case syntax.STAR:
+		xInt, xOk := x.(starlark.Int)
+		yInt, yOk := y.(starlark.Int)
+		switch {
+		case xOk && yOk:
+			if xInt.BigInt().BitLen()+yInt.BigInt().BitLen() > maxMacroIntBits {
+				return 0, fmt.Errorf("Integers are limited to %d bits", maxMacroIntBits)
+			}
+		case xOk || yOk:
+			n, sequence := xInt, y
+			if yOk {
+				n, sequence = yInt, x
+			}
+			times, ok := n.Int64()
+			if !ok || times > maxMacroValueSize {
+				return 0, errMacroMemory
+			}
+			if l := starlark.Len(sequence); l >= 0 && times > 0 {
+				return int(times) * l, nil
+			}
+		}
+	case syntax.PERCENT:
+		if format, ok := x.(starlark.String); ok {
+			return len(format) + macroSize(y, maxMacroValueSize), nil
+		}
+	case syntax.LTLT:
+		xInt, xOk := x.(starlark.Int)
+		shift, yOk := y.(starlark.Int)
+		if xOk && yOk {
+			bits, ok := shift.Int64()
+			if !ok || int64(xInt.BigInt().BitLen())+bits > maxMacroIntBits {
+				return 0, fmt.Errorf("Integers are limited to %d bits", maxMacroIntBits)
+			}
+		}
+	}
+	return 0, nil
+}
+
+func (b *macroBudget) binary(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var op string
+	var x, y starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 3, &op, &x, &y); err != nil {
+		return nil, err
+	}
+	token, ok := macroOpTokens[op]
+	if !ok {
+		return nil, fmt.Errorf("Unknown operator %s", op)
+	}
+	size, err := binarySize(token, x, y)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.charge(size); err != nil {
+		return nil, err
+	}
+	// lists are extended in place like the builtin += does
+	if list, ok := x.(*starlark.List); ok && op == "+=" {
+		extend, err := list.Attr("extend")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := starlark.Call(thread, extend, starlark.Tuple{y}, nil); err != nil {
+			return nil, err
+		}
+		return list, nil
+	}
+	return starlark.Binary(token, x, y)
+}
+
+// checkMethod checks the size of the results of the string and list methods which may grow a lot at once
+func (b *macroBudget) checkMethod(receiver starlark.Value, name string, args starlark.Tuple, kwargs []starlark.Tuple) error {
+	switch receiver := receiver.(type) {
+	case starlark.String:
+		switch name {
+		case "join":
+			if len(args) != 1 {
+				return nil
+			}
+			iterable, ok := args[0].(starlark.Iterable)
+			if !ok {
+				return nil
+			}
+			size := 0
+			iter := iterable.Iterate()
+			defer iter.Done()
+			var x starlark.Value
+			for size <= maxMacroValueSize && iter.Next(&x) {
+				size += len(receiver) + macroSize(x, maxMacroValueSize)
+			}
+			return b.charge(size)
+		case "replace":
+			if len(args) < 2 {
+				return nil
+			}
+			old, ok1 := args[0].(starlark.String)
+			replacement, ok2 := args[1].(starlark.String)
+			if !ok1 || !ok2 {
+				return nil
+			}
+			count := strings.Count(string(receiver), string(old))
+			return b.charge(len(receiver) + count*len(replacement))
+		case "format":
+			values := append(starlark.Tuple{}, args...)
+			for _, kwarg := range kwargs {
+				values = append(values, kwarg[1])
+			}
+			size := 0
+			for _, v := range values {
+				size += macroSize(v, maxMacroValueSize)
+			}
+			// every field may use every argument
+			fields := strings.Count(string(receiver), "{")
+			if size > maxMacroValueSize || fields > maxMacroValueSize {
+				return errMacroMemory
+			}
+			return b.charge(len(receiver) + fields*size)
+		}
+	case *starlark.List:
+		if name == "extend" && len(args) == 1 {
+			return b.charge(receiver.Len() + starlark.Len(args[0]))
+		}
+	}
+	return nil
+}
+
+// attr looks up an attribute. Methods are wrapped so their results are checked
+func (b *macroBudget) attr(x starlark.Value, name string) (starlark.Value, error) {
+	hasAttrs, ok := x.(starlark.HasAttrs)
+	if !ok {
+		return nil, fmt.Errorf("%s has no .%s field or method", x.Type(), name)
+	}
+	v, err := hasAttrs.Attr(name)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, fmt.Errorf("%s has no .%s field or method", x.Type(), name)
+	}
+	method, ok := v.(*starlark.Builtin)
+	if !ok {
+		return v, nil
+	}
+	return starlark.NewBuiltin(method.Name(), func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := b.checkMethod(x, name, args, kwargs); err != nil {
+			return nil, err
+		}
+		result, err := starlark.Call(thread, method, args, kwargs)
+		if err != nil {
+			return nil, err
+		}
+		return result, b.chargeLen(result)
+	}), nil
+}
+
+// builtins returns the checked builtins and the universal builtins wrapped so their results are charged
+func (b *macroBudget) builtins() starlark.StringDict {
+	builtins := starlark.StringDict{
+		"__binary": starlark.NewBuiltin("__binary", b.binary),
+		"__attr": starlark.NewBuiltin("__attr", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var x starlark.Value
+			var name string
+			if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &x, &name); err != nil {
+				return nil, err
+			}
+			return b.attr(x, name)
+		}),
+		"__charge": starlark.NewBuiltin("__charge", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var x starlark.Value
+			if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &x); err != nil {
+				return nil, err
+			}
+			return x, b.chargeLen(x)
+		}),
+	}
+	for name, v := range starlark.Universe {
+		builtin, ok := v.(*starlark.Builtin)
+		if !ok {
+			continue
+		}
+		switch name {
+		case "getattr":
+			// getattr(x, name) would hand out unchecked methods
+			builtins[name] = starlark.NewBuiltin(name, func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+				var x, fallback starlark.Value
+				var attrName string
+				if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &x, &attrName, &fallback); err != nil {
+					return nil, err
+				}
+				v, err := b.attr(x, attrName)
+				if err != nil && fallback != nil {
+					return fallback, nil
+				}
+				return v, err
+			})
+		case "str", "repr", "print", "fail":
+			// the string representation of a list may be far larger than the list itself
+			builtins[name] = starlark.NewBuiltin(name, func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+				values := append(starlark.Tuple{}, args...)
+				for _, kwarg := range kwargs {
+					values = append(values, kwarg[1])
+				}
+				if err := b.checkSize(values...); err != nil {
+					return nil, err
+				}
+				return starlark.Call(thread, builtin, args, kwargs)
+			})
+		default:
+			builtins[name] = starlark.NewBuiltin(name, func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+				result, err := starlark.Call(thread, builtin, args, kwargs)
+				if err != nil {
+					return nil, err
+				}
+				return result, b.chargeLen(result)
+			})
+		}
+	}
+	return builtins
+}
@@ -0,0 +1,81 @@
+package rooms
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func runTestMacro(t *testing.T, source string) (RollResults, error) {
+	t.Helper()
+	call := MacroCall{Name: "test", Source: source, MaxDice: 50}
+	return runMacro(testSettings(), call.MaxDice, rand.New(rand.NewSource(1)), call)
+}
+
+func TestMacroMemory(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{name: "repeat string", source: `s = "a" * 400000000`},
+		{name: "repeat list", source: `l = [1] * 400000000`},
+		{name: "repeat in steps", source: "s = \"a\" * 200000\nfor i in range(100):\n    t = s * 1\n"},
+		{name: "augmented add", source: "s = \"a\" * 1000\nfor i in range(20):\n    s += s\n"},
+		{name: "augmented repeat", source: "s = \"ab\"\ns *= 300000\n"},
+		{name: "augmented add list", source: "l = [1]\nfor i in range(25):\n    l += l\n"},
+		{name: "join", source: "l = [\"a\" * 200000] * 100\ns = \"\".join(l)\n"},
+		{name: "join separator", source: "s = (\"a\" * 100000).join([\"\"] * 100)\n"},
+		{name: "comprehension", source: `l = ["a" * 100000 for i in range(100)]`},
+		{name: "nested comprehension", source: `l = [[1] * 1000 for i in range(100) for j in range(100)]`},
+		{name: "str", source: "l = [\"a\" * 100000] * 100\ns = str(l)\n"},
+		{name: "format", source: "s = \"{}\" * 1000\nt = s.format(\"a\" * 10000)\n"},
+		{name: "shift", source: `n = 1 << 100000`},
+		{name: "slice", source: "l = [1] * 200000\nfor i in range(100):\n    m = l[:]\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := runTestMacro(t, test.source)
+			if err == nil {
+				t.Fatal("the macro succeeded")
+			}
+			if !strings.Contains(err.Error(), errMacroMemory.Error()) && !strings.Contains(err.Error(), "bits") {
+				t.Errorf("got error %v", err)
+			}
+		})
+	}
+}
+
+func TestMacroWithinLimits(t *testing.T) {
+	source := `
+r = roll("3d6")
+s = "-" * 10
+l = [x * 2 for x in r.dice]
+l += [1]
+total = r.total + len(l)
+print(s, ",".join([str(x) for x in l]))
+`
+	results, err := runTestMacro(t, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Results) != 3 || !strings.HasPrefix(results.Description, "----------") {
+		t.Errorf("got %+v", results)
+	}
+}
+
+func TestMacroReservedNames(t *testing.T) {
+	sources := []string{
+		"__binary = 1\ns = \"a\" * 400000000\n",
+		"def f(__attr):\n    return __attr\n",
+		"print(__charge(1))",
+		"x = [__y for __y in range(3)]",
+	}
+	for _, source := range sources {
+		if err := ValidateMacro("test", source); err == nil || !strings.Contains(err.Error(), "reserved") {
+			t.Errorf("%q: got %v", source, err)
+		}
+		if _, err := runTestMacro(t, source); err == nil {
+			t.Errorf("%q ran", source)
+		}
+	}
+}
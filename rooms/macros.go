@@ -0,0 +1,306 @@
+package rooms
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	starlarkresolve "go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+const (
+	// MaxMacroSize is the maximum length of the source of a macro
+	MaxMacroSize = 10000
+
+	maxMacros          = 50
+	maxMacroNameLength = 64
+	maxMacroArgs       = 10
+	// maxMacroOutput limits the printed lines which make up the description
+	maxMacroOutput = 20
+	// maxMacroSteps and maxMacroTime stop macros running in circles
+	maxMacroSteps = 1000000
+	maxMacroTime  = time.Second
+)
+
+var (
+	// ErrMacroNotFound is returned when a room has no macro with the given name
+	ErrMacroNotFound = errors.New("Macro not found")
+	// ErrTooManyMacros is returned when a room already has the maximum number of macros
+	ErrTooManyMacros = fmt.Errorf("A room can't have more than %d macros", maxMacros)
+)
+
+// RunMacroRequest runs a macro. The arguments are available to the script as args
+type RunMacroRequest struct {
+	Name string `json:"name"`
+	Args []int  `json:"args"`
+}
+
+// MacroInfo is a macro as sent to the rollers
+type MacroInfo struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+// MacroCall is a run of a macro as recorded in the log of a seeded room
+type MacroCall struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Args   []int  `json:"args,omitempty"`
+	// MaxDice is the dice limit of the server at the time of the run
+	MaxDice int `json:"maxDice,omitempty"`
+	// Run numbers the macro runs of a seeded room. Each run rolls with its own generator seeded by the room seed
+	// and the run so runs don't hold up the other rolls of the room
+	Run uint64 `json:"run"`
+}
+
+// errMacroTimeout is returned when a macro has been stopped because it took too long. Whether that happens depends
+// on the machine so such runs can't be replayed
+var errMacroTimeout = errors.New("Macro took too long")
+
+func init() {
+	// macros are short scripts. they may use if and for at the top level and sets to look for doubles.
+	// The resolver of this Starlark version only has these process wide switches. Nothing else in wuerfler uses
+	// Starlark so they only affect macros
+	starlarkresolve.AllowGlobalReassign = true
+	starlarkresolve.AllowSet = true
+}
+
+// macroPredeclared are the names a macro gets from the room
+var macroPredeclared = map[string]bool{"roll": true, "args": true}
+
+// ValidateMacro checks the name and the syntax of a macro and that it only uses known names
+func ValidateMacro(name string, source string) error {
+	if name == "" || len(name) > maxMacroNameLength {
+		return fmt.Errorf("Macro names must have between 1 and %d characters", maxMacroNameLength)
+	}
+	if len(source) > MaxMacroSize {
+		return fmt.Errorf("Macros must not be longer than %d characters", MaxMacroSize)
+	}
+	_, err := compileMacro(name, source)
+	return err
+}
+
+// runMacro executes a macro written in Starlark. roll(expression) rolls with the rules of the room and returns a
+// struct with total, dice (the kept values) and description. Printed lines become the description of the result.
+// A global total replaces the sum of all rolls. The dice rolled so far are returned even if the macro fails
+func runMacro(settings RoomSettings, maxDice int, rng *rand.Rand, call MacroCall) (RollResults, error) {
+	results := RollResults{Results: make([]RollResult, 0)}
+	system, err := lookupSystem(settings.System)
+	if err != nil {
+		return results, err
+	}
+	program, err := compileMacro(call.Name, call.Source)
+	if err != nil {
+		return results, err
+	}
+	output := make([]string, 0)
+	rolledTotal := 0
+
+	rollBuiltin := starlark.NewBuiltin("roll", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var expression string
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &expression); err != nil {
+			return nil, err
+		}
+		roll, err := system.ParseRoll(RollRequest{Expression: expression}, settings)
+		if err != nil {
+			return nil, err
+		}
+		if maxDice > 0 && len(results.Results)+roll.DiceCount() > maxDice {
+			return nil, errors.New("Too many dices")
+		}
+		rolled := system.Resolve(roll, rng)
+		markCriticals(settings, roll, rolled)
+		results.Results = append(results.Results, rolled...)
+		total := roll.Total(rolled)
+		rolledTotal += total
+
+		dice := make([]starlark.Value, 0, len(rolled))
+		for _, result := range rolled {
+			if !result.Dropped {
+				dice = append(dice, starlark.MakeInt(result.Result))
+			}
+		}
+		return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"total":       starlark.MakeInt(total),
+			"dice":        starlark.NewList(dice),
+			"description": starlark.String(system.Describe(roll, rolled)),
+		}), nil
+	})
+
+	args := make(starlark.Tuple, 0, len(call.Args))
+	for _, arg := range call.Args {
+		args = append(args, starlark.MakeInt(arg))
+	}
+	thread := &starlark.Thread{
+		Name: call.Name,
+		Print: func(_ *starlark.Thread, msg string) {
+			if len(output) < maxMacroOutput {
+				output = append(output, msg)
+			}
+		},
+	}
+	thread.SetMaxExecutionSteps(maxMacroSteps)
+	var timedOut int32
+	timer := time.AfterFunc(maxMacroTime, func() {
+		atomic.StoreInt32(&timedOut, 1)
+		thread.Cancel(errMacroTimeout.Error())
+	})
+	defer timer.Stop()
+
+	predeclared := (&macroBudget{}).builtins()
+	predeclared["roll"] = rollBuiltin
+	predeclared["args"] = args
+	globals, err := program.Init(thread, predeclared)
+	if err != nil {
+		if atomic.LoadInt32(&timedOut) != 0 {
+			return results, errMacroTimeout
+		}
+		if evalErr, ok := err.(*starlark.EvalError); ok {
+			return results, errors.New(evalErr.Msg)
+		}
+		return results, err
+	}
+
+	results.Total = rolledTotal
+	if total, ok := globals["total"]; ok {
+		t, err := starlark.AsInt32(total)
+		if err != nil {
+			return results, fmt.Errorf("total must be an int: %v", err)
+		}
+		results.Total = t
+	}
+	results.Description = strings.Join(output, ", ")
+	if results.Description == "" {
+		results.Description = fmt.Sprintf("Total %d", results.Total)
+	}
+	results.Date = time.Now()
+	return results, nil
+}
+
+func (r *roomState) macroInfos() []MacroInfo {
+	infos := make([]MacroInfo, 0, len(r.macros))
+	for name, source := range r.macros {
+		infos = append(infos, MacroInfo{Name: name, Source: source})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+func (r *roomState) sendMacros() {
+	infos := r.macroInfos()
+	for _, roller := range r.rollers {
+		r.sendEvent(roller, Event{Type: "macros", Payload: infos})
+	}
+}
+
+// runMacro runs a macro outside of the room goroutine as it may take a while. It rolls with a generator of its own
+// so the other rolls of the room don't have to wait. The result is handled like any other roll
+func (r *roomState) runMacro(from Roller, request RunMacroRequest) {
+	source, ok := r.macros[request.Name]
+	if !ok {
+		r.sendError(from, ErrMacroNotFound.Error())
+		return
+	}
+	if err := r.mayRoll(from); err != nil {
+		r.sendError(from, err.Error())
+		return
+	}
+	if len(request.Args) > maxMacroArgs {
+		r.sendError(from, fmt.Sprintf("Macros take at most %d arguments", maxMacroArgs))
+		return
+	}
+	settings := r.settings.get()
+	call := MacroCall{
+		Name:    request.Name,
+		Source:  source,
+		Args:    request.Args,
+		MaxDice: r.manager.conf.MaxDicePerRoll,
+	}
+	r.rollerWg.Add(1)
+	go func() {
+		defer r.rollerWg.Done()
+		rng, run := r.dice.macroRand()
+		call.Run = run
+		results, err := runMacro(settings, call.MaxDice, rng, call)
+		// failed runs may have rolled as well. runs stopped by the timer can't be replayed
+		if err != errMacroTimeout {
+			r.dice.record(LogEntry{Type: LogMacro, RollerID: from.ID, Settings: &settings, Macro: &call, Results: results.Results})
+		}
+		if err != nil {
+			r.reply(from, Event{Type: "error", Payload: fmt.Sprintf("Macro %s failed: %v", request.Name, err)})
+			return
+		}
+		results.RollerID = from.ID
+		results.Macro = request.Name
+		results.Seeded = r.dice.seeded()
+		select {
+		case r.roll <- results:
+		case <-from.Done:
+		}
+	}()
+}
+
+// Macros lists the macros of a room. token has to be the resume token of the owner
+func (m *Manager) Macros(roomName string, token string) ([]MacroInfo, error) {
+	var infos []MacroInfo
+	err := m.inRoom(roomName, func(r *roomState) {
+		if r.isOwnerToken(token) {
+			infos = r.macroInfos()
+		}
+	})
+	if err == nil && infos == nil {
+		err = ErrNotOwner
+	}
+	return infos, err
+}
+
+// SetMacro creates or replaces a macro of a room. token has to be the resume token of the owner
+func (m *Manager) SetMacro(roomName string, token string, name string, source string) error {
+	var macroErr error
+	err := m.inRoom(roomName, func(r *roomState) {
+		if !r.isOwnerToken(token) {
+			macroErr = ErrNotOwner
+			return
+		}
+		if _, ok := r.macros[name]; !ok && len(r.macros) >= maxMacros {
+			macroErr = ErrTooManyMacros
+			return
+		}
+		r.macros[name] = source
+		r.log.Infof("Macro %s uploaded", name)
+		r.sendMacros()
+	})
+	if err != nil {
+		return err
+	}
+	return macroErr
+}
+
+// DeleteMacro removes a macro of a room. token has to be the resume token of the owner
+func (m *Manager) DeleteMacro(roomName string, token string, name string) error {
+	var macroErr error
+	err := m.inRoom(roomName, func(r *roomState) {
+		if !r.isOwnerToken(token) {
+			macroErr = ErrNotOwner
+			return
+		}
+		if _, ok := r.macros[name]; !ok {
+			macroErr = ErrMacroNotFound
+			return
+		}
+		delete(r.macros, name)
+		r.sendMacros()
+	})
+	if err != nil {
+		return err
+	}
+	return macroErr
+}
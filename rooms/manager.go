@@ -2,16 +2,17 @@ package rooms
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"math/rand"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/goombaio/namegenerator"
+	"github.com/m0ppers/wuerfler/config"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -26,13 +27,15 @@ var (
 const (
 	// AddRollerErrorRoomNonExistent will be thrown if the room where we tried to add ourselves is non existent
 	AddRollerErrorRoomNonExistent = iota
+	// AddRollerErrorRoomClosed will be thrown if the room has already been closed and is only available as an archive
+	AddRollerErrorRoomClosed
+	// AddRollerErrorBanned will be thrown if the roller has been banned from the room
+	AddRollerErrorBanned
 )
 
-const (
-	// CachedResults controls the amount of results cached per room that is sent upon reconnect
-	CachedResults = 10
-	// RoomIdleTime determines when a room is being closed once all members left
-	RoomIdleTime = 60 * time.Second
+var (
+	// ErrTooManyRooms is returned when the configured maximum of rooms has been reached
+	ErrTooManyRooms = errors.New("Too many rooms")
 )
 
 // AddRollerError is an error thrown when trying to add rollers to a room
@@ -49,90 +52,206 @@ func NewAddRollerError(t int) *AddRollerError {
 }
 
 func (e *AddRollerError) Error() string {
-	return "Room doesn't exist"
+	switch e.Type {
+	case AddRollerErrorRoomClosed:
+		return "Room has been closed"
+	case AddRollerErrorBanned:
+		return "Banned from room"
+	default:
+		return "Room doesn't exist"
+	}
+}
+
+// UserInfo identifies a roller. The ID is stable for the lifetime of the roller, the name is just for display
+type UserInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
 }
 
 // UsersUpdateInfo will be sent whenevert something changed regarding to usernames or so on the backend (join, leave, name change)
 type UsersUpdateInfo struct {
-	Self   string   `json:"self"`
-	Others []string `json:"others"`
+	Self   UserInfo   `json:"self"`
+	Others []UserInfo `json:"others"`
+	// Spectators are only watching. They are not part of Others
+	Spectators []UserInfo `json:"spectators"`
+	// Owner is the ID of the room owner
+	Owner string `json:"owner"`
+	// NPCs are controlled by the owner. They are not part of Others
+	NPCs []UserInfo `json:"npcs"`
+	// Turn is the ID of the roller or NPC whose turn it is. Empty if there is no turn order
+	Turn      string   `json:"turn"`
+	TurnOrder []string `json:"turnOrder"`
 }
 
 // ProfileUpdateRequest is the input data when somebody tries to change their name
 type ProfileUpdateRequest struct {
-	OldName string
-	NewName string
+	RollerID string
+	NewName  string
 }
 
 // RollRequest is the request to roll some dices
 type RollRequest struct {
-	Name  string
-	Dices []uint8
+	// Dice are die names like d6, dF or the name of a custom die
+	Dice []string `json:"dice"`
+	// Expression is used instead of Dice if set. See the game systems for the syntax
+	Expression string `json:"expression"`
+	// Target is the number (or DC) the total has to reach. Optional
+	Target *int `json:"target"`
+	// Mode repeats the roll (advantage, disadvantage, best or worst of Times)
+	Mode  string `json:"mode"`
+	Times int    `json:"times"`
+	// As is the ID of an NPC the owner rolls for. Optional
+	As string `json:"as"`
+	// Secret rolls are only shown to the owner until revealed
+	Secret bool `json:"secret"`
 }
 
 // RollResult is the result of one dice
 type RollResult struct {
-	Dice   uint8 `json:"dice"`
-	Result uint8 `json:"result"`
+	Dice   string `json:"dice"`
+	Result int    `json:"result"`
+	// Label is set when the face of the die shows something else than a number
+	Label string `json:"label,omitempty"`
+	// Dropped dice don't count, like the lower die when rolling with advantage
+	Dropped bool `json:"dropped,omitempty"`
+	// Critical is either "success" or "failure" if the die shows a critical result
+	Critical string `json:"critical,omitempty"`
+}
+
+// Check is the outcome of a roll against a target number. Margin is the difference of the total and the target
+type Check struct {
+	Target  int  `json:"target"`
+	Success bool `json:"success"`
+	Margin  int  `json:"margin"`
 }
 
 // RollResults is the result of several dices of a roller
 type RollResults struct {
-	Name    string       `json:"name"`
-	Date    time.Time    `json:"date"`
-	Results []RollResult `json:"results"`
+	// ID is given when the roll is added to the history
+	ID       string       `json:"id"`
+	RollerID string       `json:"rollerId"`
+	Name     string       `json:"name"`
+	Date     time.Time    `json:"date"`
+	Results  []RollResult `json:"results"`
+	// Description is the outcome according to the game system of the room
+	Description string `json:"description,omitempty"`
+	// Table is set if this was a roll on a random table
+	Table string `json:"table,omitempty"`
+	// Mode is the roll mode of the request
+	Mode string `json:"mode,omitempty"`
+	// RollCall is the ID of the roll call this roll answered
+	RollCall string `json:"rollCall,omitempty"`
+	// Contest is the ID of the contest this roll was made for
+	Contest string `json:"contest,omitempty"`
+	// Macro is the name of the macro which made this roll
+	Macro string `json:"macro,omitempty"`
+	// ControlledBy is the ID of the owner if this roll was made for an NPC. RollerID and Name are the NPC's then
+	ControlledBy string `json:"controlledBy,omitempty"`
+	// Secret is set on secret rolls. The commitment has no results
+	Secret *Secret `json:"secret,omitempty"`
+	// Total of all kept dice and modifiers. Dice pools count their successes
+	Total int    `json:"total"`
+	Check *Check `json:"check,omitempty"`
+	// Seeded rolls come from a room with a fixed seed and can be replayed
+	Seeded bool `json:"seeded,omitempty"`
 }
 
-// Roller is our User object
-type Roller struct {
-	Name            string
-	RollRequestChan chan []uint8
-	ProfileUpdate   chan string
-	RollResultsChan chan RollResults
-	UsersUpdate     chan UsersUpdateInfo
-	RemoveSelf      chan struct{}
+// Manager manages rooms
+type Manager struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	log     *log.Logger
+	conf    config.Config
+	storage Storage
+	wg      sync.WaitGroup
+
+	mutex    sync.RWMutex
+	rooms    map[string]Room
+	archives map[string]Archive
 }
 
-// NewRoller creates a new Roller
-func NewRoller(name string) Roller {
-	return Roller{
-		Name:            name,
-		RollRequestChan: make(chan []uint8, 16),
-		ProfileUpdate:   make(chan string, 16),
-		RollResultsChan: make(chan RollResults, 16),
-		UsersUpdate:     make(chan UsersUpdateInfo, 16),
-		RemoveSelf:      make(chan struct{}),
+// NewManager creates a new manager. All rooms and archives found in storage will be restored. storage may be nil in
+// which case rooms and archives will be lost upon shutdown. Cancelling ctx shuts down all rooms
+func NewManager(ctx context.Context, log *log.Logger, conf config.Config, storage Storage) *Manager {
+	ctx, cancel := context.WithCancel(ctx)
+	m := &Manager{
+		ctx:      ctx,
+		cancel:   cancel,
+		log:      log,
+		conf:     conf,
+		storage:  storage,
+		rooms:    make(map[string]Room, 0),
+		archives: make(map[string]Archive, 0),
+	}
+
+	if storage != nil {
+		snapshots, err := storage.Load()
+		if err != nil {
+			log.Errorf("Couldn't load rooms: %v", err)
+		}
+		for _, snapshot := range snapshots {
+			room := NewRoom(snapshot.Name)
+			m.rooms[snapshot.Name] = room
+			m.startRoom(room, snapshot)
+			log.Infof("Restored room `%s`", snapshot.Name)
+		}
+		m.restoreArchives()
 	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.runArchiveCleanup()
+	}()
+	return m
 }
 
-// Room holds everything room related
-type Room struct {
-	name      string
-	addRoller chan Roller
+func (m *Manager) startRoom(room Room, snapshot Snapshot) {
+	log := m.log.WithField("room", room.name)
+	r := newRoomState(m, log, room, snapshot)
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		r.run()
+	}()
 }
 
-// NewRoom creates a new room
-func NewRoom(name string) Room {
-	return Room{
-		name:      name,
-		addRoller: make(chan Roller, 16),
+// Shutdown tells every roller that the server is going away, persists all rooms and waits until all room
+// goroutines have finished or ctx is done
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
-// Manager manages rooms
-type Manager struct {
-	log *log.Logger
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand failing means the system is seriously broken
+		panic(fmt.Sprintf("Couldn't generate random bytes: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
 
-	mutex sync.RWMutex
-	rooms map[string]Room
+// newID generates an opaque random identifier
+func newID() string {
+	return randomHex(8)
 }
 
-// NewManager creates a new manager
-func NewManager(log *log.Logger) *Manager {
-	return &Manager{
-		log:   log,
-		rooms: make(map[string]Room, 0),
-	}
+// newToken generates a secret token
+func newToken() string {
+	return randomHex(16)
 }
 
 func generateUniqueName(existing []string) string {
@@ -197,212 +316,53 @@ func makeUniqueName(name string, names []string) string {
 	return name
 }
 
-func runRoller(roller *Roller, log *logrus.Entry, removeRoller chan<- string, roll chan<- RollResults, profileUpdate chan<- ProfileUpdateRequest) {
-	for {
-		select {
-		case <-roller.RemoveSelf:
-			log.Debugf("Scheduling removal of %s", roller.Name)
-			removeRoller <- roller.Name
-			return
-		case dices := <-roller.RollRequestChan:
-			rand.Seed(time.Now().UTC().UnixNano())
-			results := make([]RollResult, 0)
-			for _, dice := range dices {
-				if dice <= 1 {
-					continue
-				}
-				results = append(results, RollResult{
-					Dice:   dice,
-					Result: uint8(1 + rand.Intn(int(dice))),
-				})
-			}
-			roll <- RollResults{
-				Name:    roller.Name,
-				Results: results,
-				Date:    time.Now(),
-			}
-		case newName := <-roller.ProfileUpdate:
-			profileUpdate <- ProfileUpdateRequest{
-				OldName: roller.Name,
-				NewName: newName,
-			}
-		}
-	}
-}
-
-func addRoller(log *logrus.Entry, rollers []*Roller, roller Roller) []*Roller {
-	rollerNames := make([]string, 0, len(rollers))
-	for _, other := range rollers {
-		rollerNames = append(rollerNames, other.Name)
-	}
-	name := makeUniqueName(roller.Name, rollerNames)
-
-	roller.Name = name
-	rollers = append(rollers, &roller)
-	log.Infof("Added user `%s`. New roller count: %d", name, len(rollers))
-
-	sendUserUpdates(log, rollers)
-	return rollers
-}
-
-func sendUserUpdates(log *logrus.Entry, rollers []*Roller) {
-	if len(rollers) == 0 {
-		return
-	}
-
-	for _, member := range rollers {
-		others := make([]string, 0, len(rollers)-1)
-		for _, other := range rollers {
-			if other.Name != member.Name {
-				others = append(others, other.Name)
-			}
-		}
-		log.Debugf("Sending friend list. Roller: %s, Others: %s", member.Name, strings.Join(others, ", "))
-		usersUpdate := UsersUpdateInfo{
-			Self:   member.Name,
-			Others: others,
-		}
-		member.UsersUpdate <- usersUpdate
-	}
-}
-
-func removeRoller(log *logrus.Entry, rollers []*Roller, name string) []*Roller {
-	log.Debugf("Removing %s", name)
-	found := false
-	for i, roller := range rollers {
-		if roller.Name == name {
-			rollers = append(rollers[:i], rollers[i+1:]...)
-			found = true
-			break
-		}
-	}
-
-	if !found {
-		names := make([]string, 0, len(rollers))
-		for _, roller := range rollers {
-			names = append(names, roller.Name)
-		}
-		log.Errorf("Couldn't find %s in room list?! in room: %s", name, strings.Join(names, ", "))
+// CreateRoom creates a new room and returns the new name
+func (m *Manager) CreateRoom(name string, settings RoomSettings) (string, error) {
+	if m.ctx.Err() != nil {
+		return "", errors.New("Shutting down")
 	}
-
-	sendUserUpdates(log, rollers)
-
-	log.Debugf("Removed %s", name)
-	return rollers
-}
-
-func (m *Manager) runRoom(log *logrus.Entry, room Room) {
-	log.Infof("Room `%s` started", room.name)
-	RoomsGauge.Inc()
-	defer func() {
-		func() {
-			m.mutex.Lock()
-			defer m.mutex.Unlock()
-			delete(m.rooms, room.name)
-		}()
-		log.Infof("Room `%s` ended", room.name)
-		RoomsGauge.Dec()
-	}()
-	rollers := make([]*Roller, 0)
-
-	removeRollerChan := make(chan string, 4)
-	roll := make(chan RollResults, 16)
-	profileUpdate := make(chan ProfileUpdateRequest, 16)
-
-	t := time.NewTimer(RoomIdleTime)
-
-	lastRolls := make([]RollResults, 0, CachedResults)
-	for {
-		select {
-		case roller := <-room.addRoller:
-			rollers = addRoller(log, rollers, roller)
-			l := len(rollers)
-			// must be ptr because goroutine is searching via name and name might change due to profile update
-			rollerPtr := rollers[l-1]
-
-			for _, lastRoll := range lastRolls {
-				rollerPtr.RollResultsChan <- lastRoll
-			}
-
-			// need to stop room end timer if this is the first user
-			if l == 1 && !t.Stop() {
-				<-t.C
-			}
-			go runRoller(rollerPtr, log, removeRollerChan, roll, profileUpdate)
-		case name := <-removeRollerChan:
-			rollers = removeRoller(log, rollers, name)
-			if len(rollers) == 0 {
-				t.Reset(RoomIdleTime)
-			}
-		case profileUpdateRequest := <-profileUpdate:
-			var l int
-			if len(rollers) <= 1 {
-				l = 0
-			} else {
-				l = len(rollers) - 1
-			}
-			var r *Roller
-			others := make([]string, 0, l)
-			for _, roller := range rollers {
-				if roller.Name == profileUpdateRequest.OldName {
-					r = roller
-				} else {
-					others = append(others, roller.Name)
-				}
-			}
-			if r == nil {
-				log.Errorf("Couldn't find roller %s in room", profileUpdateRequest.OldName)
-				continue
-			}
-			r.Name = makeUniqueName(profileUpdateRequest.NewName, others)
-			sendUserUpdates(log, rollers)
-		case r := <-roll:
-			if len(lastRolls) < CachedResults {
-				lastRolls = append(lastRolls, r)
-			} else {
-				for i := 0; i < CachedResults-1; i++ {
-					lastRolls[i] = lastRolls[i+1]
-				}
-				lastRolls[CachedResults-1] = r
-			}
-			for _, roller := range rollers {
-				roller.RollResultsChan <- r
-			}
-		case <-t.C:
-			return
-		}
-
+	if err := settings.Validate(); err != nil {
+		return "", err
 	}
-}
-
-// CreateRoom creates a new room and returns the new name
-func (m *Manager) CreateRoom(name string) (string, error) {
 	for i := 0; i < 100; i++ {
 		roomNames := func() []string {
 			m.mutex.Lock()
 			defer m.mutex.Unlock()
-			roomNames := make([]string, 0, len(m.rooms))
+			// archived rooms stay reachable under their name so they may not be reused
+			roomNames := make([]string, 0, len(m.rooms)+len(m.archives))
 			for k := range m.rooms {
 				roomNames = append(roomNames, k)
 			}
+			for k := range m.archives {
+				roomNames = append(roomNames, k)
+			}
 			return roomNames
 		}()
 		roomName := makeUniqueName(name, roomNames)
 		r := NewRoom(roomName)
-		ok := func() bool {
+		ok, err := func() (bool, error) {
 			m.mutex.Lock()
 			defer m.mutex.Unlock()
 
+			if m.conf.MaxRooms > 0 && len(m.rooms) >= m.conf.MaxRooms {
+				return false, ErrTooManyRooms
+			}
 			_, exists := m.rooms[roomName]
 			if exists {
-				return false
+				return false, nil
+			}
+			_, exists = m.archives[roomName]
+			if exists {
+				return false, nil
 			}
 			m.rooms[roomName] = r
-			return true
+			m.startRoom(r, Snapshot{Name: roomName, Settings: settings, Created: time.Now()})
+			return true, nil
 		}()
+		if err != nil {
+			return "", err
+		}
 		if ok {
-			log := m.log.WithField("room", roomName)
-			go m.runRoom(log, r)
 			return roomName, nil
 		}
 	}
@@ -418,21 +378,39 @@ func (m *Manager) Exists(roomName string) bool {
 	return ok
 }
 
-// AddRoller adds a new roller to a room
-func (m *Manager) AddRoller(roomName string, name string) (Roller, error) {
-	room, ok := func() (Room, bool) {
-		m.mutex.RLock()
-		defer m.mutex.RUnlock()
-
-		room, ok := m.rooms[roomName]
-		return room, ok
-	}()
+// lookupRoom finds an open room
+func (m *Manager) lookupRoom(roomName string) (Room, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 
+	room, ok := m.rooms[roomName]
 	if !ok {
-		return Roller{}, NewAddRollerError(AddRollerErrorRoomNonExistent)
+		if _, archived := m.archives[roomName]; archived {
+			return Room{}, NewAddRollerError(AddRollerErrorRoomClosed)
+		}
+		return Room{}, NewAddRollerError(AddRollerErrorRoomNonExistent)
+	}
+	return room, nil
+}
+
+// AddRoller adds a new roller to a room
+func (m *Manager) AddRoller(roomName string, join JoinRequest) (Roller, error) {
+	room, err := m.lookupRoom(roomName)
+	if err != nil {
+		return Roller{}, err
+	}
+	if room.bans.banned(join.Token, join.IP) {
+		return Roller{}, NewAddRollerError(AddRollerErrorBanned)
+	}
+	roller := NewRoller(join)
+	// the last rolls are sent right after joining
+	roller.RollResultsChan = make(chan RollResults, rollerBuffer+m.conf.CachedResults)
+	// the lock may not be held while waiting for the room. a busy room would stop everybody else
+	select {
+	case room.addRoller <- roller:
+	case <-room.done:
+		return Roller{}, NewAddRollerError(AddRollerErrorRoomClosed)
 	}
-	roller := NewRoller(name)
-	room.addRoller <- roller
 
 	return roller, nil
 }
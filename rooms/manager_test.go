@@ -0,0 +1,154 @@
+package rooms
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/m0ppers/wuerfler/config"
+	log "github.com/sirupsen/logrus"
+)
+
+func testConfig() config.Config {
+	return config.Config{
+		ReconnectDelay:   time.Second,
+		ArchiveRetention: time.Hour,
+		MaxArchives:      10,
+		CachedResults:    10,
+		ArchivedResults:  100,
+		RoomIdleTime:     time.Minute,
+		MaxDicePerRoll:   50,
+	}
+}
+
+// newTestManager starts a manager which is shut down at the end of the test
+func newTestManager(t *testing.T, conf config.Config, storage Storage) *Manager {
+	t.Helper()
+	logger := log.New()
+	logger.SetOutput(ioutil.Discard)
+	m := NewManager(context.Background(), logger, conf, storage)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := m.Shutdown(ctx); err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+	})
+	return m
+}
+
+// within fails the test if f doesn't return in time
+func within(t *testing.T, d time.Duration, what string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatalf("%s hangs", what)
+	}
+}
+
+// drain reads everything a roller gets until it is disconnected
+func drain(roller Roller) {
+	for {
+		select {
+		case <-roller.RollResultsChan:
+		case <-roller.UsersUpdate:
+		case <-roller.Events:
+		case <-roller.Done:
+			return
+		}
+	}
+}
+
+func TestSlowRollerDoesNotBlockManager(t *testing.T) {
+	m := newTestManager(t, testConfig(), nil)
+	name, err := m.CreateRoom("slow", DefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// never reads anything
+	stuck, err := m.AddRoller(name, JoinRequest{Name: "stuck"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	within(t, 5*time.Second, "joining", func() {
+		for i := 0; i < 2*rollerBuffer; i++ {
+			roller, err := m.AddRoller(name, JoinRequest{Name: "joiner"})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			go drain(roller)
+		}
+	})
+	within(t, 5*time.Second, "creating a room", func() {
+		if _, err := m.CreateRoom("other", DefaultSettings()); err != nil {
+			t.Error(err)
+		}
+		m.Exists(name)
+	})
+	select {
+	case <-stuck.Done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stuck roller hasn't been dropped")
+	}
+	if reason := stuck.DisconnectReason(); reason.Reason != DisconnectTooSlow {
+		t.Errorf("got disconnect reason %s", reason.Reason)
+	}
+}
+
+// expect reads everything a roller gets until an event of the given type arrives
+func expect(t *testing.T, roller Roller, eventType string) Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-roller.Events:
+			if event.Type == eventType {
+				return event
+			}
+		case <-roller.UsersUpdate:
+		case <-roller.RollResultsChan:
+		case <-roller.Done:
+			t.Fatalf("%s has been disconnected while waiting for %s", roller.Name, eventType)
+		case <-timeout:
+			t.Fatalf("%s didn't get %s", roller.Name, eventType)
+		}
+	}
+}
+
+// expectRoll reads everything a roller gets until a roll arrives
+func expectRoll(t *testing.T, roller Roller) RollResults {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case <-roller.Events:
+		case <-roller.UsersUpdate:
+		case roll := <-roller.RollResultsChan:
+			return roll
+		case <-roller.Done:
+			t.Fatalf("%s has been disconnected while waiting for a roll", roller.Name)
+		case <-timeout:
+			t.Fatalf("%s didn't get a roll", roller.Name)
+		}
+	}
+}
+
+// join adds a roller to a room and fails the test if that doesn't work
+func join(t *testing.T, m *Manager, roomName string, request JoinRequest) Roller {
+	t.Helper()
+	roller, err := m.AddRoller(roomName, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the session is always sent first
+	expect(t, roller, "session")
+	return roller
+}
@@ -0,0 +1,125 @@
+package rooms
+
+import (
+	"sync"
+)
+
+// KickRequest asks the room to remove a roller. Only the owner may kick
+type KickRequest struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+	// Ban prevents the roller from coming back for the lifetime of the room
+	Ban bool `json:"ban"`
+}
+
+// KickInfo is broadcast to the room after somebody has been kicked
+type KickInfo struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	By     string `json:"by"`
+	Reason string `json:"reason"`
+	Banned bool   `json:"banned"`
+}
+
+// Bans are the resume tokens and IPs banned from a room
+type Bans struct {
+	Tokens []string `json:"tokens"`
+	IPs    []string `json:"ips"`
+}
+
+// banList is shared between the room goroutine (writing) and the manager (checking joins)
+type banList struct {
+	mutex  sync.RWMutex
+	tokens map[string]bool
+	ips    map[string]bool
+}
+
+func newBanList() *banList {
+	return &banList{
+		tokens: make(map[string]bool),
+		ips:    make(map[string]bool),
+	}
+}
+
+func (b *banList) ban(token string, ip string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if token != "" {
+		b.tokens[token] = true
+	}
+	if ip != "" {
+		b.ips[ip] = true
+	}
+}
+
+func (b *banList) banned(token string, ip string) bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return (token != "" && b.tokens[token]) || (ip != "" && b.ips[ip])
+}
+
+func (b *banList) snapshot() Bans {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	bans := Bans{
+		Tokens: make([]string, 0, len(b.tokens)),
+		IPs:    make([]string, 0, len(b.ips)),
+	}
+	for token := range b.tokens {
+		bans.Tokens = append(bans.Tokens, token)
+	}
+	for ip := range b.ips {
+		bans.IPs = append(bans.IPs, ip)
+	}
+	return bans
+}
+
+func (b *banList) restore(bans Bans) {
+	for _, token := range bans.Tokens {
+		b.ban(token, "")
+	}
+	for _, ip := range bans.IPs {
+		b.ban("", ip)
+	}
+}
+
+func (r *roomState) kick(from Roller, request KickRequest) {
+	if from.ID != r.owner {
+		r.sendError(from, "Only the owner may kick")
+		return
+	}
+	if request.ID == from.ID {
+		r.sendError(from, "You can't kick yourself")
+		return
+	}
+	i := findRoller(r.rollers, request.ID)
+	if i < 0 {
+		r.sendError(from, "Roller not found")
+		return
+	}
+	target := r.rollers[i]
+	if request.Ban {
+		r.bans.ban(target.Token, target.IP)
+	}
+
+	message := request.Reason
+	if message == "" {
+		message = "You have been kicked by the room owner"
+	}
+	r.log.Infof("%s kicked %s (ban: %v)", from.ID, target.ID, request.Ban)
+	r.remove(target.ID, DisconnectReason{
+		Reason:  DisconnectKicked,
+		Message: message,
+	})
+
+	info := KickInfo{
+		ID:     target.ID,
+		Name:   target.Name,
+		By:     from.ID,
+		Reason: request.Reason,
+		Banned: request.Ban,
+	}
+	for _, roller := range r.rollers {
+		r.sendEvent(roller, Event{Type: "kicked", Payload: info})
+	}
+}
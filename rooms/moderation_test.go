@@ -0,0 +1,62 @@
+package rooms
+
+import "testing"
+
+func TestKick(t *testing.T) {
+	m := newTestManager(t, testConfig(), nil)
+	name, err := m.CreateRoom("kick", DefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := join(t, m, name, JoinRequest{Name: "owner", IP: "192.0.2.1"})
+	bob, err := m.AddRoller(name, JoinRequest{Name: "bob", IP: "192.0.2.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := expect(t, bob, "session").Payload.(Session)
+	carol := join(t, m, name, JoinRequest{Name: "carol", IP: "192.0.2.3"})
+
+	bob.Requests <- &KickRequest{ID: carol.ID}
+	if message := expect(t, bob, "error").Payload; message != "Only the owner may kick" {
+		t.Errorf("got error %v", message)
+	}
+	owner.Requests <- &KickRequest{ID: owner.ID}
+	if message := expect(t, owner, "error").Payload; message != "You can't kick yourself" {
+		t.Errorf("got error %v", message)
+	}
+	owner.Requests <- &KickRequest{ID: "nobody"}
+	if message := expect(t, owner, "error").Payload; message != "Roller not found" {
+		t.Errorf("got error %v", message)
+	}
+
+	owner.Requests <- &KickRequest{ID: carol.ID, Reason: "spam"}
+	info := expect(t, bob, "kicked").Payload.(KickInfo)
+	if info.ID != carol.ID || info.By != owner.ID || info.Reason != "spam" || info.Banned {
+		t.Errorf("got %+v", info)
+	}
+	<-carol.Done
+	if reason := carol.DisconnectReason(); reason.Reason != DisconnectKicked || reason.Message != "spam" {
+		t.Errorf("got disconnect reason %+v", reason)
+	}
+	// kicked without a ban so carol may come back
+	carol = join(t, m, name, JoinRequest{Name: "carol", IP: "192.0.2.3"})
+
+	owner.Requests <- &KickRequest{ID: bob.ID, Ban: true}
+	if info := expect(t, carol, "kicked").Payload.(KickInfo); info.ID != bob.ID || !info.Banned {
+		t.Errorf("got %+v", info)
+	}
+	<-bob.Done
+	if reason := bob.DisconnectReason(); reason.Message != "You have been kicked by the room owner" {
+		t.Errorf("got disconnect reason %+v", reason)
+	}
+	for _, request := range []JoinRequest{
+		{Name: "bob", Token: session.Token},
+		{Name: "bob", IP: "192.0.2.2"},
+	} {
+		_, err := m.AddRoller(name, request)
+		if err, ok := err.(*AddRollerError); !ok || err.Type != AddRollerErrorBanned {
+			t.Errorf("joining with %+v: %v", request, err)
+		}
+	}
+	join(t, m, name, JoinRequest{Name: "bob", IP: "192.0.2.4"})
+}
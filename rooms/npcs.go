@@ -0,0 +1,79 @@
+package rooms
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	maxNPCs          = 50
+	maxNPCNameLength = 64
+)
+
+// CreateNPCRequest adds a non player character the owner may roll for
+type CreateNPCRequest struct {
+	Name string `json:"name"`
+}
+
+// RemoveNPCRequest removes a non player character. Its rolls stay in the history
+type RemoveNPCRequest struct {
+	ID string `json:"id"`
+}
+
+func (r *roomState) findNPC(id string) int {
+	for i, npc := range r.npcs {
+		if npc.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *roomState) createNPC(from Roller, request CreateNPCRequest) {
+	if from.ID != r.owner {
+		r.sendError(from, "Only the owner may create NPCs")
+		return
+	}
+	if request.Name == "" || len(request.Name) > maxNPCNameLength {
+		r.sendError(from, fmt.Sprintf("NPC names must have between 1 and %d characters", maxNPCNameLength))
+		return
+	}
+	if len(r.npcs) >= maxNPCs {
+		r.sendError(from, fmt.Sprintf("A room can't have more than %d NPCs", maxNPCs))
+		return
+	}
+	names := make([]string, 0, len(r.npcs))
+	for _, npc := range r.npcs {
+		names = append(names, npc.Name)
+	}
+	r.npcs = append(r.npcs, UserInfo{ID: newID(), Name: makeUniqueName(request.Name, names)})
+	r.sendUserUpdates()
+}
+
+func (r *roomState) removeNPC(from Roller, request RemoveNPCRequest) {
+	if from.ID != r.owner {
+		r.sendError(from, "Only the owner may remove NPCs")
+		return
+	}
+	i := r.findNPC(request.ID)
+	if i < 0 {
+		r.sendError(from, "NPC not found")
+		return
+	}
+	r.npcs = append(r.npcs[:i], r.npcs[i+1:]...)
+	r.removeTracker(request.ID)
+	r.removeTurn(request.ID)
+	r.sendUserUpdates()
+}
+
+// npcFor returns the NPC the owner wants to roll for
+func (r *roomState) npcFor(from Roller, id string) (UserInfo, error) {
+	if from.ID != r.owner {
+		return UserInfo{}, errors.New("Only the owner may roll for NPCs")
+	}
+	i := r.findNPC(id)
+	if i < 0 {
+		return UserInfo{}, errors.New("NPC not found")
+	}
+	return r.npcs[i], nil
+}
@@ -0,0 +1,326 @@
+package rooms
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	// maxExactOperations limits the work of the exact calculation before falling back to Monte Carlo
+	maxExactOperations = 20000000
+	// monteCarloSamples is the number of simulated rolls when the exact calculation is too expensive
+	monteCarloSamples = 100000
+	// maxExactRange limits the number of totals a distribution may span while it is calculated exactly
+	maxExactRange = MaxSides
+	// maxOutcomes limits the outcomes of a result. Beyond it neighbouring totals are merged
+	maxOutcomes = 1000
+)
+
+// ProbabilityRequest asks for the odds of a roll expression. Target is optional
+type ProbabilityRequest struct {
+	Expression string `json:"expression"`
+	Target     *int   `json:"target"`
+}
+
+// Outcome is the probability of the totals from Total to To. To equals Total unless there were too many totals to
+// list them one by one
+type Outcome struct {
+	Total       int     `json:"total"`
+	To          int     `json:"to"`
+	Probability float64 `json:"probability"`
+}
+
+// Distribution describes the possible totals of a roll. Dice pools with a target count successes instead of adding up
+type Distribution struct {
+	Expression string    `json:"expression"`
+	Exact      bool      `json:"exact"`
+	Samples    int       `json:"samples,omitempty"`
+	Outcomes   []Outcome `json:"outcomes"`
+	Mean       float64   `json:"mean"`
+	StdDev     float64   `json:"stdDev"`
+	Target     *int      `json:"target,omitempty"`
+	// AtLeast is the probability to reach the target
+	AtLeast *float64 `json:"atLeast,omitempty"`
+}
+
+// distribution maps totals starting at min to their probability
+type distribution struct {
+	min int
+	p   []float64
+}
+
+// ParseRoll parses an expression with the rules of a game system as if rolled in a room with default settings
+func ParseRoll(system string, expression string) (Roll, error) {
+	gameSystem, err := lookupSystem(system)
+	if err != nil {
+		return Roll{}, err
+	}
+	settings := DefaultSettings()
+	settings.System = system
+	settings.AllowedDice = nil
+	return gameSystem.ParseRoll(RollRequest{Expression: expression}, settings)
+}
+
+// Probability calculates the distribution of the totals of a roll. Expensive rolls are estimated by Monte Carlo simulation
+func Probability(roll Roll, target *int) Distribution {
+	d, ok := exactDistribution(roll)
+	result := Distribution{Exact: ok, Target: target}
+	var outcomes []Outcome
+	if ok {
+		outcomes = d.outcomes()
+	} else {
+		outcomes = simulate(roll, monteCarloSamples)
+		result.Samples = monteCarloSamples
+	}
+
+	atLeast := 0.0
+	for _, outcome := range outcomes {
+		result.Mean += float64(outcome.Total) * outcome.Probability
+		if target != nil && outcome.Total >= *target {
+			atLeast += outcome.Probability
+		}
+	}
+	variance := 0.0
+	for _, outcome := range outcomes {
+		variance += math.Pow(float64(outcome.Total)-result.Mean, 2) * outcome.Probability
+	}
+	result.StdDev = math.Sqrt(variance)
+	if target != nil {
+		result.AtLeast = &atLeast
+	}
+	result.Outcomes = merge(outcomes, maxOutcomes)
+	return result
+}
+
+// merge joins neighbouring outcomes so there are at most max of them
+func merge(outcomes []Outcome, max int) []Outcome {
+	if len(outcomes) <= max {
+		return outcomes
+	}
+	size := (len(outcomes) + max - 1) / max
+	merged := make([]Outcome, 0, max)
+	for start := 0; start < len(outcomes); start += size {
+		end := start + size
+		if end > len(outcomes) {
+			end = len(outcomes)
+		}
+		outcome := Outcome{Total: outcomes[start].Total, To: outcomes[end-1].To}
+		for _, o := range outcomes[start:end] {
+			outcome.Probability += o.Probability
+		}
+		merged = append(merged, outcome)
+	}
+	return merged
+}
+
+// outcomes lists the totals which may occur
+func (d distribution) outcomes() []Outcome {
+	outcomes := make([]Outcome, 0, len(d.p))
+	for i, p := range d.p {
+		if p == 0 {
+			continue
+		}
+		total := d.min + i
+		outcomes = append(outcomes, Outcome{Total: total, To: total, Probability: p})
+	}
+	return outcomes
+}
+
+// values are the values of all faces of a die
+func (d Die) values() []int {
+	values := make([]int, 0, len(d.Faces)+d.Sides)
+	for _, face := range d.Faces {
+		values = append(values, face.Value)
+	}
+	for value := 1; value <= d.Sides; value++ {
+		values = append(values, value)
+	}
+	return values
+}
+
+// faceDistribution is the distribution of the score of a single die
+func faceDistribution(group DiceGroup) (distribution, bool) {
+	values := group.Die.values()
+	counts := make(map[int]int)
+	for _, value := range values {
+		counts[group.score(value)]++
+	}
+	return fromCounts(counts, len(values))
+}
+
+// fromCounts builds a distribution of scores which occurred count times out of total. It fails if the scores are
+// spread too far
+func fromCounts(counts map[int]int, total int) (distribution, bool) {
+	first := true
+	min, max := 0, 0
+	for score := range counts {
+		if first || score < min {
+			min = score
+		}
+		if first || score > max {
+			max = score
+		}
+		first = false
+	}
+	if max-min < 0 || max-min >= maxExactRange {
+		return distribution{}, false
+	}
+	d := distribution{min: min, p: make([]float64, max-min+1)}
+	for score, count := range counts {
+		d.p[score-min] = float64(count) / float64(total)
+	}
+	return d, true
+}
+
+func convolve(a distribution, b distribution) distribution {
+	d := distribution{min: a.min + b.min, p: make([]float64, len(a.p)+len(b.p)-1)}
+	for i, pa := range a.p {
+		if pa == 0 {
+			continue
+		}
+		for j, pb := range b.p {
+			d.p[i+j] += pa * pb
+		}
+	}
+	return d
+}
+
+func negate(d distribution) distribution {
+	negated := distribution{min: -(d.min + len(d.p) - 1), p: make([]float64, len(d.p))}
+	for i, p := range d.p {
+		negated.p[len(d.p)-1-i] = p
+	}
+	return negated
+}
+
+// exactDistribution convolves the groups. It gives up if that would take too long
+func exactDistribution(roll Roll) (distribution, bool) {
+	total := distribution{min: roll.Modifier, p: []float64{1}}
+	operations := 0
+	for _, group := range roll.Groups {
+		faces := len(group.Die.Faces) + group.Die.Sides
+		if faces > maxExactOperations/group.Count {
+			return total, false
+		}
+		face, ok := faceDistribution(group)
+		if !ok {
+			return total, false
+		}
+		var groupDistribution distribution
+		if group.KeepHighest > 0 || group.KeepLowest > 0 {
+			combinations := math.Pow(float64(faces), float64(group.Count))
+			if combinations*float64(group.Count) > maxExactOperations {
+				return total, false
+			}
+			operations += int(combinations) * group.Count
+			if groupDistribution, ok = keptDistribution(group); !ok {
+				return total, false
+			}
+		} else {
+			groupDistribution = distribution{min: 0, p: []float64{1}}
+			for i := 0; i < group.Count; i++ {
+				operations += len(groupDistribution.p) * len(face.p)
+				if operations > maxExactOperations {
+					return total, false
+				}
+				groupDistribution = convolve(groupDistribution, face)
+			}
+		}
+		if group.Negative {
+			groupDistribution = negate(groupDistribution)
+		}
+		operations += len(total.p) * len(groupDistribution.p)
+		if operations > maxExactOperations {
+			return total, false
+		}
+		total = convolve(total, groupDistribution)
+	}
+	return total, true
+}
+
+// keptDistribution enumerates every combination of a group which keeps the highest or lowest dice
+func keptDistribution(group DiceGroup) (distribution, bool) {
+	values := group.Die.values()
+	kept := group.KeepHighest
+	if kept == 0 {
+		kept = group.KeepLowest
+	}
+
+	indices := make([]int, group.Count)
+	rolled := make([]int, group.Count)
+	counts := make(map[int]int)
+	combinations := 0
+	for {
+		for i, index := range indices {
+			rolled[i] = values[index]
+		}
+		if group.KeepHighest > 0 {
+			sort.Sort(sort.Reverse(sort.IntSlice(rolled)))
+		} else {
+			sort.Ints(rolled)
+		}
+		score := 0
+		for _, value := range rolled[:kept] {
+			score += group.score(value)
+		}
+		counts[score]++
+		combinations++
+
+		// next combination
+		i := 0
+		for ; i < len(indices); i++ {
+			indices[i]++
+			if indices[i] < len(values) {
+				break
+			}
+			indices[i] = 0
+		}
+		if i == len(indices) {
+			break
+		}
+	}
+	return fromCounts(counts, combinations)
+}
+
+// simulate estimates the distribution by rolling. Only totals which were rolled are kept so the totals may spread
+// as far as they like
+func simulate(roll Roll, samples int) []Outcome {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	counts := make(map[int]int)
+	for i := 0; i < samples; i++ {
+		counts[roll.Total(rollDice(roll, rng))]++
+	}
+	outcomes := make([]Outcome, 0, len(counts))
+	for total, count := range counts {
+		outcomes = append(outcomes, Outcome{Total: total, To: total, Probability: float64(count) / float64(samples)})
+	}
+	sort.Slice(outcomes, func(i, j int) bool {
+		return outcomes[i].Total < outcomes[j].Total
+	})
+	return outcomes
+}
+
+// probability sends the odds of an expression with the rules of the room to a single roller. It runs in the goroutine
+// of the roller
+func (r *roomState) probability(roller Roller, request ProbabilityRequest) {
+	settings := r.settings.get()
+	system, err := lookupSystem(settings.System)
+	if err != nil {
+		r.reply(roller, Event{Type: "error", Payload: err.Error()})
+		return
+	}
+	roll, err := system.ParseRoll(RollRequest{Expression: request.Expression}, settings)
+	if err != nil {
+		r.reply(roller, Event{Type: "error", Payload: err.Error()})
+		return
+	}
+	if max := r.manager.conf.MaxDicePerRoll; max > 0 && roll.DiceCount() > max {
+		r.reply(roller, Event{Type: "error", Payload: "Too many dices"})
+		return
+	}
+	distribution := Probability(roll, request.Target)
+	distribution.Expression = request.Expression
+	r.reply(roller, Event{Type: "probability", Payload: distribution})
+}
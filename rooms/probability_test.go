@@ -0,0 +1,105 @@
+package rooms
+
+import (
+	"math"
+	"testing"
+)
+
+func TestProbability(t *testing.T) {
+	target := 7
+	tests := []struct {
+		expression string
+		exact      bool
+		outcomes   int
+		mean       float64
+		// first and last total of the outcomes
+		first, last int
+		atLeast     float64
+	}{
+		{expression: "2d6", exact: true, outcomes: 11, mean: 7, first: 2, last: 12, atLeast: 21.0 / 36},
+		{expression: "d6+3", exact: true, outcomes: 6, mean: 6.5, first: 4, last: 9, atLeast: 0.5},
+		{expression: "d20-d6", exact: true, outcomes: 25, mean: 7, first: -5, last: 19, atLeast: 0.525},
+		{expression: "4d6kh3", exact: true, outcomes: 16, mean: 12.2446, first: 3, last: 18, atLeast: 0.97222},
+		{expression: "4dF", exact: true, outcomes: 9, mean: 0, first: -4, last: 4, atLeast: 0},
+		{expression: "6d10>=8", exact: true, outcomes: 7, mean: 1.8, first: 0, last: 6, atLeast: 0},
+		{expression: "d1000000", exact: true, outcomes: maxOutcomes, mean: 500000.5, first: 1, last: 1000000, atLeast: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			roll, err := ParseRoll(SystemGeneric, test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := Probability(roll, &target)
+			if d.Exact != test.exact {
+				t.Errorf("exact is %v", d.Exact)
+			}
+			if len(d.Outcomes) != test.outcomes {
+				t.Fatalf("got %d outcomes, want %d", len(d.Outcomes), test.outcomes)
+			}
+			if d.Outcomes[0].Total != test.first || d.Outcomes[len(d.Outcomes)-1].To != test.last {
+				t.Errorf("outcomes go from %d to %d", d.Outcomes[0].Total, d.Outcomes[len(d.Outcomes)-1].To)
+			}
+			sum := 0.0
+			for _, outcome := range d.Outcomes {
+				sum += outcome.Probability
+			}
+			if math.Abs(sum-1) > 1e-9 {
+				t.Errorf("probabilities add up to %v", sum)
+			}
+			if math.Abs(d.Mean-test.mean) > 1e-3 {
+				t.Errorf("mean is %v, want %v", d.Mean, test.mean)
+			}
+			if d.AtLeast == nil {
+				t.Fatal("at least is missing")
+			}
+			if math.Abs(*d.AtLeast-test.atLeast) > 1e-3 {
+				t.Errorf("at least is %v, want %v", *d.AtLeast, test.atLeast)
+			}
+		})
+	}
+}
+
+func TestProbabilitySimulated(t *testing.T) {
+	tests := []struct {
+		expression string
+		mean       float64
+	}{
+		{expression: "50d1000000", mean: 50 * 500000.5},
+		{expression: "8d20kh2", mean: 34.3},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			roll, err := ParseRoll(SystemGeneric, test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := Probability(roll, nil)
+			if d.Exact || d.Samples != monteCarloSamples {
+				t.Errorf("expected a simulation, got exact %v with %d samples", d.Exact, d.Samples)
+			}
+			if len(d.Outcomes) == 0 || len(d.Outcomes) > maxOutcomes {
+				t.Errorf("got %d outcomes", len(d.Outcomes))
+			}
+			if math.Abs(d.Mean-test.mean)/test.mean > 0.01 {
+				t.Errorf("mean is %v, want about %v", d.Mean, test.mean)
+			}
+			if d.AtLeast != nil {
+				t.Error("at least without target")
+			}
+		})
+	}
+}
+
+func TestProbabilitySpreadFaces(t *testing.T) {
+	// faces this far apart can't be calculated densely
+	die := Die{Name: "far", Faces: []Face{{Value: -1 << 40}, {Value: 1 << 40}}}
+	roll := Roll{Groups: []DiceGroup{{Count: 2, Die: die}}}
+	d := Probability(roll, nil)
+	if d.Exact {
+		t.Error("expected a simulation")
+	}
+	if len(d.Outcomes) != 3 {
+		t.Errorf("got %d outcomes, want 3", len(d.Outcomes))
+	}
+}
@@ -0,0 +1,213 @@
+package rooms
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	defaultRollCallDeadline = 60 * time.Second
+	maxRollCallDeadline     = 10 * time.Minute
+)
+
+// RollCallRequest asks every roller to roll an expression. Only the owner may call for rolls.
+// Deadline is in seconds
+type RollCallRequest struct {
+	Title      string `json:"title"`
+	Expression string `json:"expression"`
+	Target     *int   `json:"target"`
+	Deadline   int    `json:"deadline"`
+}
+
+// AnswerRollCallRequest rolls the expression of the current roll call. The mode is up to the roller
+type AnswerRollCallRequest struct {
+	ID    string `json:"id"`
+	Mode  string `json:"mode"`
+	Times int    `json:"times"`
+}
+
+// RollCallInfo is broadcast when a roll call starts
+type RollCallInfo struct {
+	ID         string     `json:"id"`
+	By         string     `json:"by"`
+	Title      string     `json:"title"`
+	Expression string     `json:"expression"`
+	Target     *int       `json:"target,omitempty"`
+	Deadline   time.Time  `json:"deadline"`
+	Rollers    []UserInfo `json:"rollers"`
+}
+
+// RollCallEntry is the result of a single roller. Missing rollers didn't roll in time
+type RollCallEntry struct {
+	UserInfo
+	Missing bool   `json:"missing"`
+	Total   int    `json:"total"`
+	Check   *Check `json:"check,omitempty"`
+}
+
+// RollCallSummary is broadcast once everybody has rolled or the deadline has passed. Entries are sorted by total
+type RollCallSummary struct {
+	ID         string          `json:"id"`
+	Title      string          `json:"title"`
+	Expression string          `json:"expression"`
+	Target     *int            `json:"target,omitempty"`
+	Entries    []RollCallEntry `json:"entries"`
+}
+
+type rollCall struct {
+	RollCallInfo
+	results map[string]RollResults
+	timer   *time.Timer
+}
+
+func (r *roomState) startRollCall(from Roller, request RollCallRequest) {
+	if from.ID != r.owner {
+		r.sendError(from, "Only the owner may call for rolls")
+		return
+	}
+	if r.rollCall != nil {
+		r.sendError(from, "There is already a roll call")
+		return
+	}
+	// make sure the expression can be rolled before bothering everybody
+	settings := r.settings.get()
+	system, err := lookupSystem(settings.System)
+	if err == nil {
+		_, err = system.ParseRoll(RollRequest{Expression: request.Expression}, settings)
+	}
+	if err != nil {
+		r.sendError(from, err.Error())
+		return
+	}
+	deadline := time.Duration(request.Deadline) * time.Second
+	if deadline <= 0 {
+		deadline = defaultRollCallDeadline
+	}
+	if deadline > maxRollCallDeadline {
+		deadline = maxRollCallDeadline
+	}
+
+	rollers := make([]UserInfo, 0, len(r.rollers))
+	for _, roller := range r.rollers {
+		if roller.ID != from.ID && !roller.Spectator {
+			rollers = append(rollers, UserInfo{ID: roller.ID, Name: roller.Name})
+		}
+	}
+	if len(rollers) == 0 {
+		r.sendError(from, "Nobody is there to roll")
+		return
+	}
+
+	call := &rollCall{
+		RollCallInfo: RollCallInfo{
+			ID:         newID(),
+			By:         from.ID,
+			Title:      request.Title,
+			Expression: request.Expression,
+			Target:     request.Target,
+			Deadline:   time.Now().Add(deadline),
+			Rollers:    rollers,
+		},
+		results: make(map[string]RollResults),
+	}
+	id := call.ID
+	call.timer = r.after(deadline, func(r *roomState) {
+		if r.rollCall != nil && r.rollCall.ID == id {
+			r.finishRollCall()
+		}
+	})
+	r.rollCall = call
+	r.log.Infof("%s started roll call %s", from.ID, id)
+	for _, roller := range r.rollers {
+		r.sendEvent(roller, Event{Type: "rollcall", Payload: call.RollCallInfo})
+	}
+}
+
+func (r *roomState) answerRollCall(from Roller, request AnswerRollCallRequest) {
+	call := r.rollCall
+	if call == nil || call.ID != request.ID {
+		r.sendError(from, "The roll call is over")
+		return
+	}
+	called := false
+	for _, roller := range call.Rollers {
+		called = called || roller.ID == from.ID
+	}
+	if !called {
+		r.sendError(from, "You are not part of this roll call")
+		return
+	}
+	if _, ok := call.results[from.ID]; ok {
+		r.sendError(from, "You have already rolled")
+		return
+	}
+	// no mayRoll here. The GM has called on everybody to roll so the turn order doesn't apply
+
+	roll, err := r.resolveRoll(from, RollRequest{
+		Expression: call.Expression,
+		Target:     call.Target,
+		Mode:       request.Mode,
+		Times:      request.Times,
+	})
+	if err != nil {
+		r.sendError(from, err.Error())
+		return
+	}
+	roll.Name = from.Name
+	roll.RollCall = call.ID
+	call.results[from.ID] = roll
+	r.addToHistory(&roll)
+	for _, roller := range r.rollers {
+		r.sendRoll(roller, roll)
+	}
+	r.checkRollCall()
+}
+
+// checkRollCall finishes the roll call early if everybody still in the room has rolled
+func (r *roomState) checkRollCall() {
+	call := r.rollCall
+	if call == nil {
+		return
+	}
+	for _, roller := range call.Rollers {
+		_, rolled := call.results[roller.ID]
+		if !rolled && findRoller(r.rollers, roller.ID) >= 0 {
+			return
+		}
+	}
+	call.timer.Stop()
+	r.finishRollCall()
+}
+
+func (r *roomState) finishRollCall() {
+	call := r.rollCall
+	r.rollCall = nil
+	summary := RollCallSummary{
+		ID:         call.ID,
+		Title:      call.Title,
+		Expression: call.Expression,
+		Target:     call.Target,
+		Entries:    make([]RollCallEntry, 0, len(call.Rollers)),
+	}
+	for _, roller := range call.Rollers {
+		entry := RollCallEntry{UserInfo: roller, Missing: true}
+		if result, ok := call.results[roller.ID]; ok {
+			entry.Name = result.Name
+			entry.Missing = false
+			entry.Total = result.Total
+			entry.Check = result.Check
+		}
+		summary.Entries = append(summary.Entries, entry)
+	}
+	sort.SliceStable(summary.Entries, func(i, j int) bool {
+		a, b := summary.Entries[i], summary.Entries[j]
+		if a.Missing != b.Missing {
+			return !a.Missing
+		}
+		return a.Total > b.Total
+	})
+	r.log.Infof("Roll call %s finished", call.ID)
+	for _, roller := range r.rollers {
+		r.sendEvent(roller, Event{Type: "rollcallsummary", Payload: summary})
+	}
+}
@@ -0,0 +1,58 @@
+package rooms
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRollCall(t *testing.T) {
+	m := newTestManager(t, testConfig(), nil)
+	name, err := m.CreateRoom("rollcall", DefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gm := join(t, m, name, JoinRequest{Name: "gm"})
+	bob := join(t, m, name, JoinRequest{Name: "bob"})
+	carol := join(t, m, name, JoinRequest{Name: "carol"})
+
+	bob.Requests <- &RollCallRequest{Expression: "d20"}
+	if message := expect(t, bob, "error").Payload; message != "Only the owner may call for rolls" {
+		t.Errorf("got error %v", message)
+	}
+
+	start := time.Now()
+	target := 5
+	gm.Requests <- &RollCallRequest{Title: "Perception", Expression: "d20+10", Target: &target, Deadline: 1}
+	call := expect(t, bob, "rollcall").Payload.(RollCallInfo)
+	if len(call.Rollers) != 2 {
+		t.Errorf("called %+v", call.Rollers)
+	}
+
+	bob.Requests <- &AnswerRollCallRequest{ID: call.ID}
+	roll := expectRoll(t, bob)
+	if roll.RollCall != call.ID || roll.Check == nil || !roll.Check.Success {
+		t.Errorf("got roll %+v", roll)
+	}
+	bob.Requests <- &AnswerRollCallRequest{ID: call.ID}
+	if message := expect(t, bob, "error").Payload; message != "You have already rolled" {
+		t.Errorf("got error %v", message)
+	}
+	gm.Requests <- &AnswerRollCallRequest{ID: call.ID}
+	if message := expect(t, gm, "error").Payload; message != "You are not part of this roll call" {
+		t.Errorf("got error %v", message)
+	}
+
+	// carol doesn't answer so the roll call ends at the deadline
+	summary := expect(t, carol, "rollcallsummary").Payload.(RollCallSummary)
+	if time.Since(start) < time.Second {
+		t.Error("the roll call ended before the deadline")
+	}
+	if len(summary.Entries) != 2 || summary.Entries[0].ID != bob.ID || summary.Entries[0].Missing ||
+		summary.Entries[1].ID != carol.ID || !summary.Entries[1].Missing {
+		t.Errorf("got summary %+v", summary)
+	}
+	carol.Requests <- &AnswerRollCallRequest{ID: call.ID}
+	if message := expect(t, carol, "error").Payload; message != "The roll call is over" {
+		t.Errorf("got error %v", message)
+	}
+}
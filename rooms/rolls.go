@@ -0,0 +1,176 @@
+package rooms
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	// ModeAdvantage rolls twice and keeps the higher total
+	ModeAdvantage = "advantage"
+	// ModeDisadvantage rolls twice and keeps the lower total
+	ModeDisadvantage = "disadvantage"
+	// ModeBest rolls n times and keeps the highest total
+	ModeBest = "best"
+	// ModeWorst rolls n times and keeps the lowest total
+	ModeWorst = "worst"
+
+	maxRepetitions = 10
+)
+
+// repetitions returns how often a roll has to be made for its mode and if the highest total counts
+func repetitions(request RollRequest) (int, bool, error) {
+	switch request.Mode {
+	case "":
+		return 1, true, nil
+	case ModeAdvantage:
+		return 2, true, nil
+	case ModeDisadvantage:
+		return 2, false, nil
+	case ModeBest, ModeWorst:
+		if request.Times < 2 || request.Times > maxRepetitions {
+			return 0, false, fmt.Errorf("Best and worst of need between 2 and %d rolls", maxRepetitions)
+		}
+		return request.Times, request.Mode == ModeBest, nil
+	}
+	return 0, false, fmt.Errorf("Unknown mode %s", request.Mode)
+}
+
+// resolveRoll rolls the dice of a request according to the rules of the room
+func (r *roomState) resolveRoll(roller Roller, request RollRequest) (RollResults, error) {
+	var results RollResults
+	var err error
+	r.dice.use(func(rng *rand.Rand) *LogEntry {
+		// the settings have to be read while holding the dice so the roll is logged after the settings it used
+		settings := r.settings.get()
+		results, err = resolve(settings, r.manager.conf.MaxDicePerRoll, rng, request)
+		if err != nil {
+			return nil
+		}
+		return &LogEntry{Type: LogRoll, RollerID: roller.ID, Roll: &request, Results: results.Results}
+	})
+	results.RollerID = roller.ID
+	results.Seeded = r.dice.seeded()
+	return results, err
+}
+
+// resolve rolls the dice of a request with the given settings. If the mode repeats the roll only the dice of
+// the counting repetition are kept. The dice of all others are dropped. maxDice is only checked if positive
+func resolve(settings RoomSettings, maxDice int, rng *rand.Rand, request RollRequest) (RollResults, error) {
+	system, err := lookupSystem(settings.System)
+	if err != nil {
+		return RollResults{}, err
+	}
+	roll, err := system.ParseRoll(request, settings)
+	if err != nil {
+		return RollResults{}, err
+	}
+	if request.Mode != "" {
+		if err := settings.allows(FeatureModes); err != nil {
+			return RollResults{}, err
+		}
+	}
+	times, highest, err := repetitions(request)
+	if err != nil {
+		return RollResults{}, err
+	}
+	// dividing can't overflow like multiplying the count
+	if maxDice > 0 && roll.DiceCount() > maxDice/times {
+		return RollResults{}, errors.New("Too many dices")
+	}
+
+	repeated := make([][]RollResult, times)
+	counting := 0
+	totals := make([]int, times)
+	for i := range repeated {
+		repeated[i] = system.Resolve(roll, rng)
+		markCriticals(settings, roll, repeated[i])
+		totals[i] = roll.Total(repeated[i])
+		if (highest && totals[i] > totals[counting]) || (!highest && totals[i] < totals[counting]) {
+			counting = i
+		}
+	}
+
+	results := make([]RollResult, 0, len(repeated)*len(repeated[0]))
+	for i, repetition := range repeated {
+		for _, result := range repetition {
+			if i != counting {
+				result.Dropped = true
+				result.Critical = ""
+			}
+			results = append(results, result)
+		}
+	}
+
+	total := totals[counting]
+	var check *Check
+	if request.Target != nil {
+		check = &Check{
+			Target:  *request.Target,
+			Success: total >= *request.Target,
+			Margin:  total - *request.Target,
+		}
+	}
+	return RollResults{
+		Results:     results,
+		Description: system.Describe(roll, repeated[counting]),
+		Mode:        request.Mode,
+		Total:       total,
+		Check:       check,
+		Date:        time.Now(),
+	}, nil
+}
+
+// markCriticals marks the critical results of a roll
+func markCriticals(settings RoomSettings, roll Roll, results []RollResult) {
+	roll.eachGroup(results, func(group DiceGroup, groupResults []RollResult) {
+		criticals := settings.criticals(group.Die)
+		for i := range groupResults {
+			criticals.mark(&groupResults[i])
+		}
+	})
+}
+
+// rollInRoom resolves rolls which need the room state: rolls for NPCs and secret rolls
+func (r *roomState) rollInRoom(from Roller, request RollRequest) {
+	var npc *UserInfo
+	if request.As != "" {
+		info, err := r.npcFor(from, request.As)
+		if err != nil {
+			r.sendError(from, err.Error())
+			return
+		}
+		npc = &info
+	}
+	if !request.Secret {
+		if err := r.mayRoll(from); err != nil {
+			r.sendError(from, err.Error())
+			return
+		}
+	}
+	if request.Secret && len(r.secrets) >= maxSecrets {
+		r.sendError(from, fmt.Sprintf("There can't be more than %d unrevealed secret rolls", maxSecrets))
+		return
+	}
+	roll, err := r.resolveRoll(from, request)
+	if err != nil {
+		r.sendError(from, err.Error())
+		return
+	}
+	roll.Name = from.Name
+	if npc != nil {
+		roll.RollerID = npc.ID
+		roll.Name = npc.Name
+		roll.ControlledBy = from.ID
+	}
+	if request.Secret {
+		r.commit(roll)
+		return
+	}
+	r.addToHistory(&roll)
+	for _, roller := range r.rollers {
+		r.sendRoll(roller, roll)
+	}
+}
@@ -0,0 +1,821 @@
+package rooms
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DisconnectShutdown is the disconnect reason when the server is going down
+	DisconnectShutdown = "shutdown"
+	// DisconnectRoomClosed is the disconnect reason when the room has been closed
+	DisconnectRoomClosed = "roomClosed"
+	// DisconnectRoomFull is the disconnect reason when there was no space left in the room
+	DisconnectRoomFull = "roomFull"
+	// DisconnectKicked is the disconnect reason when a roller has been kicked out of the room
+	DisconnectKicked = "kicked"
+	// DisconnectBanned is the disconnect reason when a banned roller tries to join
+	DisconnectBanned = "banned"
+	// DisconnectNoSpectators is the disconnect reason when a spectator tries to join a room not allowing spectators
+	DisconnectNoSpectators = "noSpectators"
+	// DisconnectTooSlow is the disconnect reason when a roller doesn't keep up with the messages of the room
+	DisconnectTooSlow = "tooSlow"
+
+	// rollerBuffer is how many messages may wait for a roller. Rollers falling further behind are disconnected
+	rollerBuffer = 64
+)
+
+// Event is a message for a single roller that doesn't need its own channel. Type becomes the websocket message type
+type Event struct {
+	Type    string
+	Payload interface{}
+}
+
+// Session is sent to a roller after joining. The token allows resuming the identity when reconnecting and must be kept secret
+type Session struct {
+	ID    string `json:"id"`
+	Token string `json:"token"`
+}
+
+// JoinRequest contains everything needed to add a roller to a room
+type JoinRequest struct {
+	Name string
+	// Token is the resume token of a previous session. May be empty
+	Token string
+	IP    string
+	// Spectator joins read only
+	Spectator bool
+}
+
+// Notice is a message from the server operators
+type Notice struct {
+	Message string    `json:"message"`
+	Date    time.Time `json:"date"`
+}
+
+// DisconnectReason tells a roller why the room dropped it. An empty Reason means the roller left on its own
+type DisconnectReason struct {
+	Reason      string `json:"reason"`
+	Message     string `json:"message"`
+	ReconnectIn int    `json:"reconnectIn,omitempty"`
+}
+
+// Roller is our User object
+type Roller struct {
+	ID              string
+	Name            string
+	Token           string
+	IP              string
+	Spectator       bool
+	Joined          time.Time
+	RollRequestChan chan RollRequest
+	ProfileUpdate   chan string
+	RollResultsChan chan RollResults
+	UsersUpdate     chan UsersUpdateInfo
+	Events          chan Event
+	// Requests carries everything else a roller wants from the room. See handleRequest
+	Requests   chan interface{}
+	RemoveSelf chan struct{}
+	// Done is closed as soon as the roller is no longer part of the room
+	Done chan struct{}
+
+	disconnectReason *DisconnectReason
+}
+
+// NewRoller creates a new Roller
+func NewRoller(join JoinRequest) Roller {
+	return Roller{
+		ID:               newID(),
+		Name:             join.Name,
+		Token:            join.Token,
+		IP:               join.IP,
+		Spectator:        join.Spectator,
+		RollRequestChan:  make(chan RollRequest, 16),
+		ProfileUpdate:    make(chan string, 16),
+		RollResultsChan:  make(chan RollResults, rollerBuffer),
+		UsersUpdate:      make(chan UsersUpdateInfo, rollerBuffer),
+		Events:           make(chan Event, rollerBuffer),
+		Requests:         make(chan interface{}, 16),
+		RemoveSelf:       make(chan struct{}),
+		Done:             make(chan struct{}),
+		disconnectReason: &DisconnectReason{},
+	}
+}
+
+// DisconnectReason returns why the roller has been dropped. Only valid after Done has been closed
+func (r Roller) DisconnectReason() DisconnectReason {
+	return *r.disconnectReason
+}
+
+// disconnect must only be called once and only by the room goroutine
+func (r Roller) disconnect(reason DisconnectReason) {
+	*r.disconnectReason = reason
+	close(r.Done)
+}
+
+// Room holds everything room related
+type Room struct {
+	name string
+	// addRoller is unbuffered so a roller is either taken by the room or the sender sees done
+	addRoller chan Roller
+	// ops are executed by the room goroutine. this is how everybody outside gets at the room state. Like addRoller
+	// it is unbuffered
+	ops  chan func(r *roomState)
+	bans *banList
+	// done is closed once the room doesn't accept any new rollers
+	done chan struct{}
+}
+
+// NewRoom creates a new room
+func NewRoom(name string) Room {
+	return Room{
+		name:      name,
+		addRoller: make(chan Roller),
+		ops:       make(chan func(r *roomState)),
+		bans:      newBanList(),
+		done:      make(chan struct{}),
+	}
+}
+
+// roomState is everything owned by the room goroutine
+type roomState struct {
+	Room
+	log     *logrus.Entry
+	manager *Manager
+
+	settings     *sharedSettings
+	dice         *dicer
+	created      time.Time
+	lastActivity time.Time
+	// owner is the ID of the roller who joined first. The owner acts as GM
+	owner string
+	// sessions maps resume tokens to the identity they resume
+	sessions map[string]UserInfo
+	closed   bool
+	idle     *time.Timer
+	rollers  []Roller
+	// members contains everybody who has ever been in the room
+	members []UserInfo
+	history []RollResults
+	stats   RoomStats
+	decks   map[string]*Deck
+	tables  map[string]Table
+	// macros maps macro names to their source
+	macros map[string]string
+	// npcs are rolled for by the owner
+	npcs []UserInfo
+	// secrets are the unrevealed secret rolls
+	secrets  []RollResults
+	trackers []Tracker
+	// turns is the turn order and turn the index of whoever has the turn
+	turns []string
+	turn  int
+	// rollCall is the running roll call of the owner if there is one
+	rollCall *rollCall
+	contests map[string]*contest
+	timers   map[string]*roomTimer
+	// timerGeneration counts the started timers. See roomTimer
+	timerGeneration uint64
+	// timerSync is set while running timers are broadcast regularly
+	timerSync *time.Timer
+	// slow are the IDs of rollers which couldn't keep up. They are dropped before the next message is handled
+	slow map[string]bool
+
+	removeRoller  chan string
+	roll          chan RollResults
+	profileUpdate chan ProfileUpdateRequest
+	requests      chan roomRequest
+	rollerWg      sync.WaitGroup
+}
+
+// roomRequest is a request of a roller forwarded to the room
+type roomRequest struct {
+	from    string
+	request interface{}
+}
+
+func newRoomState(m *Manager, log *logrus.Entry, room Room, snapshot Snapshot) *roomState {
+	if snapshot.History == nil {
+		snapshot.History = make([]RollResults, 0)
+	}
+	if snapshot.Members == nil {
+		snapshot.Members = make([]UserInfo, 0)
+	}
+	if snapshot.Sessions == nil {
+		snapshot.Sessions = make(map[string]UserInfo)
+	}
+	if snapshot.Decks == nil {
+		snapshot.Decks = make(map[string]*Deck)
+	}
+	if snapshot.Tables == nil {
+		snapshot.Tables = make(map[string]Table)
+	}
+	if snapshot.Macros == nil {
+		snapshot.Macros = make(map[string]string)
+	}
+	if snapshot.NPCs == nil {
+		snapshot.NPCs = make([]UserInfo, 0)
+	}
+	if snapshot.Secrets == nil {
+		snapshot.Secrets = make([]RollResults, 0)
+	}
+	if snapshot.Trackers == nil {
+		snapshot.Trackers = make([]Tracker, 0)
+	}
+	if snapshot.Turns == nil {
+		snapshot.Turns = make([]string, 0)
+	}
+	room.bans.restore(snapshot.Bans)
+	dice := newDicer(snapshot.Settings.Seed, snapshot.Log, snapshot.Draws)
+	if dice.seeded() && len(dice.log.Entries) == 0 {
+		dice.record(LogEntry{Type: LogSettings, Settings: &snapshot.Settings})
+	}
+	return &roomState{
+		Room:          room,
+		log:           log,
+		manager:       m,
+		settings:      &sharedSettings{settings: snapshot.Settings},
+		dice:          dice,
+		created:       snapshot.Created,
+		lastActivity:  time.Now(),
+		owner:         snapshot.Owner,
+		sessions:      snapshot.Sessions,
+		rollers:       make([]Roller, 0),
+		members:       snapshot.Members,
+		history:       snapshot.History,
+		stats:         snapshot.Stats,
+		decks:         snapshot.Decks,
+		tables:        snapshot.Tables,
+		macros:        snapshot.Macros,
+		npcs:          snapshot.NPCs,
+		secrets:       snapshot.Secrets,
+		trackers:      snapshot.Trackers,
+		turns:         snapshot.Turns,
+		turn:          snapshot.Turn,
+		contests:      make(map[string]*contest),
+		timers:        make(map[string]*roomTimer),
+		slow:          make(map[string]bool),
+		removeRoller:  make(chan string, 4),
+		roll:          make(chan RollResults, 16),
+		profileUpdate: make(chan ProfileUpdateRequest, 16),
+		requests:      make(chan roomRequest, 16),
+	}
+}
+
+func (r *roomState) runRoller(roller Roller) {
+	log := r.log
+	for {
+		select {
+		case <-roller.Done:
+			return
+		case <-roller.RemoveSelf:
+			log.Debugf("Scheduling removal of %s", roller.ID)
+			select {
+			case r.removeRoller <- roller.ID:
+			case <-roller.Done:
+			}
+			return
+		case request := <-roller.RollRequestChan:
+			if roller.Spectator {
+				r.reply(roller, Event{Type: "error", Payload: "Spectators may not roll"})
+				continue
+			}
+			// only the room knows the NPCs, keeps the secrets and knows whose turn it is
+			if request.As != "" || request.Secret || r.settings.get().TurnsOnly {
+				select {
+				case r.requests <- roomRequest{from: roller.ID, request: &request}:
+				case <-roller.Done:
+					return
+				}
+				continue
+			}
+			results, err := r.resolveRoll(roller, request)
+			if err != nil {
+				r.reply(roller, Event{Type: "error", Payload: err.Error()})
+				continue
+			}
+			// the name is filled in by the room. it is the only one knowing the current one
+			select {
+			case r.roll <- results:
+			case <-roller.Done:
+				return
+			}
+		case newName := <-roller.ProfileUpdate:
+			if roller.Spectator {
+				r.reply(roller, Event{Type: "error", Payload: "Spectators may not change their profile"})
+				continue
+			}
+			select {
+			case r.profileUpdate <- ProfileUpdateRequest{
+				RollerID: roller.ID,
+				NewName:  newName,
+			}:
+			case <-roller.Done:
+				return
+			}
+		case request := <-roller.Requests:
+			// calculating odds doesn't need the room and may take a while
+			if probability, ok := request.(*ProbabilityRequest); ok {
+				r.probability(roller, *probability)
+				continue
+			}
+			select {
+			case r.requests <- roomRequest{from: roller.ID, request: request}:
+			case <-roller.Done:
+				return
+			}
+		}
+	}
+}
+
+// handleRequest dispatches everything a roller sent via its Requests channel
+func (r *roomState) handleRequest(from string, request interface{}) {
+	i := findRoller(r.rollers, from)
+	if i < 0 {
+		r.log.Errorf("Dropping request of unknown roller %s", from)
+		return
+	}
+	r.lastActivity = time.Now()
+	if r.rollers[i].Spectator {
+		r.sendError(r.rollers[i], "Spectators may only watch")
+		return
+	}
+	switch request := request.(type) {
+	case *KickRequest:
+		r.kick(r.rollers[i], *request)
+	case *UpdateSettingsRequest:
+		r.updateSettings(r.rollers[i], *request)
+	case *CreateDeckRequest:
+		r.createDeck(r.rollers[i], *request)
+	case *ShuffleRequest:
+		r.shuffleDeck(r.rollers[i], *request)
+	case *ReshuffleRequest:
+		r.reshuffleDeck(r.rollers[i], *request)
+	case *DrawRequest:
+		r.draw(r.rollers[i], *request)
+	case *DiscardRequest:
+		r.discard(r.rollers[i], *request)
+	case *RollTableRequest:
+		r.rollTable(r.rollers[i], *request)
+	case *RollCallRequest:
+		r.startRollCall(r.rollers[i], *request)
+	case *AnswerRollCallRequest:
+		r.answerRollCall(r.rollers[i], *request)
+	case *ChallengeRequest:
+		r.challenge(r.rollers[i], *request)
+	case *ContestRollRequest:
+		r.rollContest(r.rollers[i], *request)
+	case *RunMacroRequest:
+		r.runMacro(r.rollers[i], *request)
+	case *CreateNPCRequest:
+		r.createNPC(r.rollers[i], *request)
+	case *RemoveNPCRequest:
+		r.removeNPC(r.rollers[i], *request)
+	case *RollRequest:
+		r.rollInRoom(r.rollers[i], *request)
+	case *RevealRequest:
+		r.reveal(r.rollers[i], *request)
+	case *UpdateCounterRequest:
+		r.updateCounter(r.rollers[i], *request)
+	case *TagRequest:
+		r.tag(r.rollers[i], *request)
+	case *TurnRequest:
+		r.changeTurns(r.rollers[i], *request)
+	case *TimerRequest:
+		r.controlTimer(r.rollers[i], *request)
+	default:
+		r.log.Errorf("Unhandled request %T", request)
+	}
+}
+
+// sendError tells a single roller that one of its requests failed. Must only be called by the room goroutine
+func (r *roomState) sendError(roller Roller, message string) {
+	r.sendEvent(roller, Event{Type: "error", Payload: message})
+}
+
+// reply sends an event to a roller from outside the room goroutine. It gives up once the roller is gone or the
+// server shuts down so the room never waits for it when closing
+func (r *roomState) reply(roller Roller, event Event) {
+	select {
+	case roller.Events <- event:
+	case <-roller.Done:
+	case <-r.manager.ctx.Done():
+	}
+}
+
+// sendEvent hands an event to a roller without blocking the room. Must only be called by the room goroutine
+func (r *roomState) sendEvent(roller Roller, event Event) {
+	if r.slow[roller.ID] {
+		return
+	}
+	select {
+	case roller.Events <- event:
+	default:
+		r.slow[roller.ID] = true
+	}
+}
+
+// sendRoll hands a roll to a roller without blocking the room. Must only be called by the room goroutine
+func (r *roomState) sendRoll(roller Roller, roll RollResults) {
+	if r.slow[roller.ID] {
+		return
+	}
+	select {
+	case roller.RollResultsChan <- roll:
+	default:
+		r.slow[roller.ID] = true
+	}
+}
+
+// sendUsersUpdate hands the users to a roller without blocking the room. Must only be called by the room goroutine
+func (r *roomState) sendUsersUpdate(roller Roller, update UsersUpdateInfo) {
+	if r.slow[roller.ID] {
+		return
+	}
+	select {
+	case roller.UsersUpdate <- update:
+	default:
+		r.slow[roller.ID] = true
+	}
+}
+
+// dropSlowRollers disconnects the rollers which didn't keep up. A dead connection would otherwise stop the whole room
+func (r *roomState) dropSlowRollers() {
+	// removing a roller informs the others which may turn out to be slow as well
+	for len(r.slow) > 0 {
+		for id := range r.slow {
+			delete(r.slow, id)
+			if findRoller(r.rollers, id) < 0 {
+				continue
+			}
+			r.log.Infof("Dropping %s. It doesn't keep up", id)
+			r.remove(id, DisconnectReason{
+				Reason:      DisconnectTooSlow,
+				Message:     "Your connection is too slow",
+				ReconnectIn: 1,
+			})
+		}
+	}
+}
+
+func findRoller(rollers []Roller, id string) int {
+	for i, roller := range rollers {
+		if roller.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func addRoller(log *logrus.Entry, rollers []Roller, roller Roller) []Roller {
+	rollerNames := make([]string, 0, len(rollers))
+	for _, other := range rollers {
+		rollerNames = append(rollerNames, other.Name)
+	}
+	name := makeUniqueName(roller.Name, rollerNames)
+
+	roller.Name = name
+	rollers = append(rollers, roller)
+	log.Infof("Added user `%s` (%s). New roller count: %d", name, roller.ID, len(rollers))
+
+	return rollers
+}
+
+func (r *roomState) sendUserUpdates() {
+	log := r.log
+	rollers := r.rollers
+	if len(rollers) == 0 {
+		return
+	}
+
+	for _, member := range rollers {
+		others := make([]UserInfo, 0, len(rollers)-1)
+		spectators := make([]UserInfo, 0)
+		otherNames := make([]string, 0, len(rollers)-1)
+		for _, other := range rollers {
+			if other.ID == member.ID {
+				continue
+			}
+			if other.Spectator {
+				spectators = append(spectators, UserInfo{ID: other.ID, Name: other.Name})
+			} else {
+				others = append(others, UserInfo{ID: other.ID, Name: other.Name})
+			}
+			otherNames = append(otherNames, other.Name)
+		}
+		log.Debugf("Sending friend list. Roller: %s, Others: %s", member.Name, strings.Join(otherNames, ", "))
+		usersUpdate := UsersUpdateInfo{
+			Self:       UserInfo{ID: member.ID, Name: member.Name},
+			Others:     others,
+			Spectators: spectators,
+			Owner:      r.owner,
+			NPCs:       r.npcs,
+			Turn:       r.turnHolder(),
+			TurnOrder:  r.turns,
+		}
+		r.sendUsersUpdate(member, usersUpdate)
+	}
+}
+
+func removeRoller(log *logrus.Entry, rollers []Roller, id string, reason DisconnectReason) []Roller {
+	log.Debugf("Removing %s", id)
+	i := findRoller(rollers, id)
+	if i >= 0 {
+		rollers[i].disconnect(reason)
+		rollers = append(rollers[:i], rollers[i+1:]...)
+	} else {
+		ids := make([]string, 0, len(rollers))
+		for _, roller := range rollers {
+			ids = append(ids, roller.ID)
+		}
+		log.Errorf("Couldn't find %s in room list?! in room: %s", id, strings.Join(ids, ", "))
+	}
+
+	log.Debugf("Removed %s", id)
+	return rollers
+}
+
+func (r *roomState) snapshot() Snapshot {
+	log, draws := r.dice.snapshot()
+	return Snapshot{
+		Name:     r.name,
+		Settings: r.settings.get(),
+		Created:  r.created,
+		History:  r.history,
+		Members:  r.members,
+		Stats:    r.stats,
+		Owner:    r.owner,
+		Sessions: r.sessions,
+		Bans:     r.bans.snapshot(),
+		Decks:    r.decks,
+		Tables:   r.tables,
+		Macros:   r.macros,
+		NPCs:     r.npcs,
+		Secrets:  r.secrets,
+		Trackers: r.trackers,
+		Turns:    r.turns,
+		Turn:     r.turn,
+		Log:      log,
+		Draws:    draws,
+	}
+}
+
+// resumeSession gives a roller its old identity if it presented a known token. Otherwise it gets a fresh token
+func (r *roomState) resumeSession(roller *Roller) {
+	session, ok := r.sessions[roller.Token]
+	if ok && findRoller(r.rollers, session.ID) < 0 {
+		roller.ID = session.ID
+		if roller.Name == "" {
+			roller.Name = session.Name
+		}
+	} else {
+		roller.Token = newToken()
+	}
+	if r.owner == "" && !roller.Spectator {
+		r.owner = roller.ID
+	}
+}
+
+func (r *roomState) updateMember(roller Roller) {
+	info := UserInfo{ID: roller.ID, Name: roller.Name}
+	r.sessions[roller.Token] = info
+	// the archive only lists people who took part
+	if roller.Spectator {
+		return
+	}
+	for i, member := range r.members {
+		if member.ID == roller.ID {
+			r.members[i] = info
+			return
+		}
+	}
+	r.members = append(r.members, info)
+	r.stats.Members = len(r.members)
+}
+
+func (r *roomState) lastRolls() []RollResults {
+	cached := r.manager.conf.CachedResults
+	if len(r.history) <= cached {
+		return r.history
+	}
+	return r.history[len(r.history)-cached:]
+}
+
+// addToHistory gives a roll its ID and keeps it for the archive
+func (r *roomState) addToHistory(roll *RollResults) {
+	roll.ID = newID()
+	// secret rolls are counted once they are revealed
+	if roll.Secret == nil || roll.Secret.Nonce != "" {
+		r.stats.Rolls++
+		r.stats.Dice += len(roll.Results)
+	}
+	archived := r.manager.conf.ArchivedResults
+	if archived <= 0 {
+		return
+	}
+	if len(r.history) >= archived {
+		r.history = append(r.history[:0], r.history[len(r.history)-archived+1:]...)
+	}
+	r.history = append(r.history, *roll)
+}
+
+// after executes f in the room goroutine once d has passed. Nothing happens if the room has been closed in the meantime
+func (r *roomState) after(d time.Duration, f func(r *roomState)) *time.Timer {
+	room := r.Room
+	return time.AfterFunc(d, func() {
+		// see AddRoller
+		select {
+		case room.ops <- f:
+		case <-room.done:
+		}
+	})
+}
+
+// remove drops a roller from the room and starts the idle timer if it was the last one
+func (r *roomState) remove(id string, reason DisconnectReason) {
+	r.rollers = removeRoller(r.log, r.rollers, id, reason)
+	r.sendUserUpdates()
+	r.checkRollCall()
+	r.lastActivity = time.Now()
+	if len(r.rollers) == 0 {
+		r.idle.Reset(r.manager.conf.RoomIdleTime)
+	}
+}
+
+// close stops accepting new rollers and drops everyone still in the room
+func (r *roomState) close(reason DisconnectReason) {
+	if r.closed {
+		return
+	}
+	r.closed = true
+	close(r.done)
+	func() {
+		r.manager.mutex.Lock()
+		defer r.manager.mutex.Unlock()
+		delete(r.manager.rooms, r.name)
+	}()
+	// addRoller is unbuffered and senders give up once done is closed so nothing can be added after this point
+	for drained := false; !drained; {
+		select {
+		case roller := <-r.addRoller:
+			roller.disconnect(reason)
+		case op := <-r.ops:
+			// somebody is waiting for this one
+			op(r)
+		default:
+			drained = true
+		}
+	}
+	for _, roller := range r.rollers {
+		roller.disconnect(reason)
+	}
+	r.rollers = r.rollers[:0]
+	r.rollerWg.Wait()
+}
+
+// end closes the room for good and archives it
+func (r *roomState) end(reason DisconnectReason) {
+	if r.closed {
+		return
+	}
+	r.close(reason)
+	r.manager.archive(r.archive())
+	if r.manager.storage != nil {
+		if err := r.manager.storage.Delete(r.name); err != nil {
+			r.log.Errorf("Couldn't delete room from storage: %v", err)
+		}
+	}
+}
+
+func (r *roomState) shutdown() {
+	delay := int(r.manager.conf.ReconnectDelay / time.Second)
+	r.close(DisconnectReason{
+		Reason:      DisconnectShutdown,
+		Message:     fmt.Sprintf("Server shutting down, reconnect in %d seconds", delay),
+		ReconnectIn: delay,
+	})
+	if r.manager.storage == nil {
+		return
+	}
+	if err := r.manager.storage.Save(r.snapshot()); err != nil {
+		r.log.Errorf("Couldn't save room: %v", err)
+	}
+}
+
+func (r *roomState) run() {
+	log := r.log
+	log.Infof("Room `%s` started", r.name)
+	RoomsGauge.Inc()
+	defer func() {
+		log.Infof("Room `%s` ended", r.name)
+		RoomsGauge.Dec()
+	}()
+
+	conf := r.manager.conf
+	r.idle = time.NewTimer(conf.RoomIdleTime)
+
+	for {
+		r.dropSlowRollers()
+		select {
+		case roller := <-r.addRoller:
+			if conf.MaxRollersPerRoom > 0 && len(r.rollers) >= conf.MaxRollersPerRoom {
+				log.Infof("Rejecting %s. Room is full", roller.ID)
+				roller.disconnect(DisconnectReason{
+					Reason:  DisconnectRoomFull,
+					Message: "Room is full",
+				})
+				continue
+			}
+			if roller.Spectator && !r.settings.get().AllowSpectators {
+				roller.disconnect(DisconnectReason{
+					Reason:  DisconnectNoSpectators,
+					Message: "This room doesn't allow spectators",
+				})
+				continue
+			}
+			r.resumeSession(&roller)
+			r.rollers = addRoller(log, r.rollers, roller)
+			l := len(r.rollers)
+			r.rollers[l-1].Joined = time.Now()
+			r.lastActivity = r.rollers[l-1].Joined
+			r.updateMember(r.rollers[l-1])
+			r.sendEvent(roller, Event{Type: "session", Payload: Session{ID: roller.ID, Token: roller.Token}})
+			r.sendEvent(roller, r.roomInfo())
+			r.sendEvent(roller, Event{Type: "decks", Payload: r.deckInfos()})
+			r.sendEvent(roller, Event{Type: "hand", Payload: r.hand(roller.ID)})
+			r.sendEvent(roller, Event{Type: "tables", Payload: r.tableNames()})
+			r.sendEvent(roller, Event{Type: "macros", Payload: r.macroInfos()})
+			r.sendEvent(roller, Event{Type: "trackers", Payload: r.trackerInfos()})
+			r.sendEvent(roller, Event{Type: "timers", Payload: r.timerInfos()})
+			if roller.ID == r.owner {
+				r.sendSecrets()
+			}
+			r.sendUserUpdates()
+
+			for _, lastRoll := range r.lastRolls() {
+				r.sendRoll(roller, lastRoll)
+			}
+
+			// need to stop room end timer if this is the first user
+			if l == 1 && !r.idle.Stop() {
+				<-r.idle.C
+			}
+			r.rollerWg.Add(1)
+			go func() {
+				defer r.rollerWg.Done()
+				r.runRoller(roller)
+			}()
+		case id := <-r.removeRoller:
+			r.remove(id, DisconnectReason{})
+		case profileUpdateRequest := <-r.profileUpdate:
+			i := findRoller(r.rollers, profileUpdateRequest.RollerID)
+			if i < 0 {
+				log.Errorf("Couldn't find roller %s in room", profileUpdateRequest.RollerID)
+				continue
+			}
+			others := make([]string, 0, len(r.rollers))
+			for _, roller := range r.rollers {
+				if roller.ID != profileUpdateRequest.RollerID {
+					others = append(others, roller.Name)
+				}
+			}
+			r.rollers[i].Name = makeUniqueName(profileUpdateRequest.NewName, others)
+			r.updateMember(r.rollers[i])
+			r.lastActivity = time.Now()
+			r.sendUserUpdates()
+		case roll := <-r.roll:
+			i := findRoller(r.rollers, roll.RollerID)
+			if i < 0 {
+				log.Errorf("Dropping roll of unknown roller %s", roll.RollerID)
+				continue
+			}
+			roll.Name = r.rollers[i].Name
+			r.lastActivity = roll.Date
+			r.addToHistory(&roll)
+			for _, roller := range r.rollers {
+				r.sendRoll(roller, roll)
+			}
+		case request := <-r.requests:
+			r.handleRequest(request.from, request.request)
+		case op := <-r.ops:
+			op(r)
+			if r.closed {
+				return
+			}
+		case <-r.idle.C:
+			r.end(DisconnectReason{
+				Reason:  DisconnectRoomClosed,
+				Message: "Room closed",
+			})
+			return
+		case <-r.manager.ctx.Done():
+			r.shutdown()
+			return
+		}
+
+	}
+}
@@ -0,0 +1,177 @@
+package rooms
+
+import (
+	"context"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// memStorage keeps everything in memory
+type memStorage struct {
+	mutex     sync.Mutex
+	snapshots map[string]Snapshot
+	archives  map[string]Archive
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{snapshots: make(map[string]Snapshot), archives: make(map[string]Archive)}
+}
+
+func (s *memStorage) Save(snapshot Snapshot) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.snapshots[snapshot.Name] = snapshot
+	return nil
+}
+
+func (s *memStorage) Delete(roomName string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.snapshots, roomName)
+	return nil
+}
+
+func (s *memStorage) Load() ([]Snapshot, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	snapshots := make([]Snapshot, 0, len(s.snapshots))
+	for _, snapshot := range s.snapshots {
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+func (s *memStorage) SaveArchive(archive Archive) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.archives[archive.Name] = archive
+	return nil
+}
+
+func (s *memStorage) DeleteArchive(roomName string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.archives, roomName)
+	return nil
+}
+
+func (s *memStorage) LoadArchives() ([]Archive, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	archives := make([]Archive, 0, len(s.archives))
+	for _, archive := range s.archives {
+		archives = append(archives, archive)
+	}
+	return archives, nil
+}
+
+func TestShutdownWithUnreadErrors(t *testing.T) {
+	logger := log.New()
+	logger.SetOutput(ioutil.Discard)
+	storage := newMemStorage()
+	m := NewManager(context.Background(), logger, testConfig(), storage)
+	name, err := m.CreateRoom("errors", DefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AddRoller(name, JoinRequest{Name: "owner"}); err != nil {
+		t.Fatal(err)
+	}
+	spectator, err := m.AddRoller(name, JoinRequest{Name: "watcher", Spectator: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the spectator never reads the errors. the rolls go to its own goroutine and are answered from there
+	for i := 0; i < 2*rollerBuffer; i++ {
+		select {
+		case spectator.RollRequestChan <- RollRequest{Expression: "d6"}:
+			continue
+		case <-time.After(100 * time.Millisecond):
+		}
+		break
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if _, ok := storage.snapshots[name]; !ok {
+		t.Error("the room hasn't been saved")
+	}
+}
+
+// expectUsers reads everything a roller gets until a users update matches
+func expectUsers(t *testing.T, roller Roller, matches func(UsersUpdateInfo) bool) UsersUpdateInfo {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case update := <-roller.UsersUpdate:
+			if matches(update) {
+				return update
+			}
+		case <-roller.Events:
+		case <-roller.RollResultsChan:
+		case <-roller.Done:
+			t.Fatalf("%s has been disconnected while waiting for a users update", roller.Name)
+		case <-timeout:
+			t.Fatalf("%s didn't get the expected users update", roller.Name)
+		}
+	}
+}
+
+func TestSpectators(t *testing.T) {
+	m := newTestManager(t, testConfig(), nil)
+	name, err := m.CreateRoom("spectators", DefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first one joining doesn't become the owner if only watching
+	spectator := join(t, m, name, JoinRequest{Name: "watcher", Spectator: true})
+	alice := join(t, m, name, JoinRequest{Name: "alice"})
+	update := expectUsers(t, alice, func(update UsersUpdateInfo) bool { return len(update.Spectators) == 1 })
+	if update.Owner != alice.ID || update.Spectators[0].ID != spectator.ID || len(update.Others) != 0 {
+		t.Errorf("got %+v", update)
+	}
+
+	spectator.RollRequestChan <- RollRequest{Expression: "d6"}
+	if message := expect(t, spectator, "error").Payload; message != "Spectators may not roll" {
+		t.Errorf("got error %v", message)
+	}
+	spectator.Requests <- &RollCallRequest{Expression: "d20"}
+	if message := expect(t, spectator, "error").Payload; message != "Spectators may only watch" {
+		t.Errorf("got error %v", message)
+	}
+	spectator.ProfileUpdate <- "player"
+	if message := expect(t, spectator, "error").Payload; message != "Spectators may not change their profile" {
+		t.Errorf("got error %v", message)
+	}
+
+	alice.RollRequestChan <- RollRequest{Expression: "d6"}
+	if roll := expectRoll(t, spectator); roll.RollerID != alice.ID {
+		t.Errorf("got roll %+v", roll)
+	}
+}
+
+func TestSpectatorsNotAllowed(t *testing.T) {
+	m := newTestManager(t, testConfig(), nil)
+	settings := DefaultSettings()
+	settings.AllowSpectators = false
+	name, err := m.CreateRoom("players", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spectator, err := m.AddRoller(name, JoinRequest{Name: "watcher", Spectator: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-spectator.Done
+	if reason := spectator.DisconnectReason(); reason.Reason != DisconnectNoSpectators {
+		t.Errorf("got disconnect reason %+v", reason)
+	}
+}
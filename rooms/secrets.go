@@ -0,0 +1,88 @@
+package rooms
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// maxSecrets limits the unrevealed secret rolls of a room
+const maxSecrets = 50
+
+// Secret marks a secret roll. Until the owner reveals it everybody only gets the hash. The revealed roll carries the
+// data and the nonce as well so everybody can check that the hash is the hex encoded sha256 of Nonce + Data
+type Secret struct {
+	ID    string `json:"id"`
+	Hash  string `json:"hash"`
+	Data  string `json:"data,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// RevealRequest asks the room to show a secret roll to everybody. Only the owner may reveal secret rolls
+type RevealRequest struct {
+	ID string `json:"id"`
+}
+
+// commit keeps a roll secret. Everybody gets the hash of the roll, the owner gets the roll itself
+func (r *roomState) commit(roll RollResults) {
+	data, err := json.Marshal(&roll)
+	if err != nil {
+		r.log.Errorf("Couldn't encode secret roll: %v", err)
+		return
+	}
+	nonce := newToken()
+	sum := sha256.Sum256(append([]byte(nonce), data...))
+	roll.Secret = &Secret{
+		ID:    newID(),
+		Hash:  hex.EncodeToString(sum[:]),
+		Data:  string(data),
+		Nonce: nonce,
+	}
+	r.secrets = append(r.secrets, roll)
+
+	commitment := RollResults{
+		RollerID:     roll.RollerID,
+		Name:         roll.Name,
+		Date:         roll.Date,
+		Results:      []RollResult{},
+		ControlledBy: roll.ControlledBy,
+		Seeded:       roll.Seeded,
+		Secret:       &Secret{ID: roll.Secret.ID, Hash: roll.Secret.Hash},
+	}
+	r.addToHistory(&commitment)
+	for _, roller := range r.rollers {
+		r.sendRoll(roller, commitment)
+	}
+	r.sendSecrets()
+}
+
+// sendSecrets sends the unrevealed secret rolls to the owner
+func (r *roomState) sendSecrets() {
+	i := findRoller(r.rollers, r.owner)
+	if i >= 0 {
+		// revealing removes from r.secrets in place while the event may not have been sent yet
+		secrets := make([]RollResults, len(r.secrets))
+		copy(secrets, r.secrets)
+		r.sendEvent(r.rollers[i], Event{Type: "secrets", Payload: secrets})
+	}
+}
+
+func (r *roomState) reveal(from Roller, request RevealRequest) {
+	if from.ID != r.owner {
+		r.sendError(from, "Only the owner may reveal secret rolls")
+		return
+	}
+	for i, roll := range r.secrets {
+		if roll.Secret.ID != request.ID {
+			continue
+		}
+		r.secrets = append(r.secrets[:i], r.secrets[i+1:]...)
+		r.addToHistory(&roll)
+		for _, roller := range r.rollers {
+			r.sendRoll(roller, roll)
+		}
+		r.sendSecrets()
+		return
+	}
+	r.sendError(from, "Secret roll not found")
+}
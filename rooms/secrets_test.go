@@ -0,0 +1,85 @@
+package rooms
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSecretRolls(t *testing.T) {
+	m := newTestManager(t, testConfig(), nil)
+	name, err := m.CreateRoom("secrets", DefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := join(t, m, name, JoinRequest{Name: "owner"})
+	bob := join(t, m, name, JoinRequest{Name: "bob"})
+	carol := join(t, m, name, JoinRequest{Name: "carol"})
+
+	bob.RollRequestChan <- RollRequest{Expression: "3d6", Secret: true}
+	commitment := expectRoll(t, carol)
+	if commitment.Secret == nil || commitment.Secret.Data != "" || commitment.Secret.Nonce != "" || len(commitment.Results) != 0 {
+		t.Fatalf("got commitment %+v", commitment)
+	}
+	// the owner got the empty list when joining
+	var secrets []RollResults
+	for len(secrets) == 0 {
+		secrets = expect(t, owner, "secrets").Payload.([]RollResults)
+	}
+	if len(secrets) != 1 || secrets[0].Secret.ID != commitment.Secret.ID || len(secrets[0].Results) != 3 {
+		t.Fatalf("got secrets %+v", secrets)
+	}
+
+	bob.Requests <- &RevealRequest{ID: commitment.Secret.ID}
+	if message := expect(t, bob, "error").Payload; message != "Only the owner may reveal secret rolls" {
+		t.Errorf("got error %v", message)
+	}
+	owner.Requests <- &RevealRequest{ID: commitment.Secret.ID}
+	var revealed RollResults
+	for revealed.Secret == nil || revealed.Secret.Data == "" {
+		revealed = expectRoll(t, carol)
+	}
+	secret := revealed.Secret
+	sum := sha256.Sum256([]byte(secret.Nonce + secret.Data))
+	if secret.ID != commitment.Secret.ID || secret.Hash != commitment.Secret.Hash || hex.EncodeToString(sum[:]) != secret.Hash {
+		t.Errorf("the revealed roll doesn't match the commitment: %+v", secret)
+	}
+	var committed RollResults
+	if err := json.Unmarshal([]byte(secret.Data), &committed); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(committed.Results, revealed.Results) || len(revealed.Results) != 3 {
+		t.Errorf("committed %+v, revealed %+v", committed.Results, revealed.Results)
+	}
+	if secrets := expect(t, owner, "secrets").Payload.([]RollResults); len(secrets) != 0 {
+		t.Errorf("got secrets %+v", secrets)
+	}
+
+	owner.Requests <- &RevealRequest{ID: commitment.Secret.ID}
+	if message := expect(t, owner, "error").Payload; message != "Secret roll not found" {
+		t.Errorf("got error %v", message)
+	}
+}
+
+func TestSecretRollsLimit(t *testing.T) {
+	m := newTestManager(t, testConfig(), nil)
+	name, err := m.CreateRoom("secrets", DefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := join(t, m, name, JoinRequest{Name: "owner"})
+	go drain(owner)
+	bob := join(t, m, name, JoinRequest{Name: "bob"})
+	for i := 0; i < maxSecrets; i++ {
+		bob.RollRequestChan <- RollRequest{Expression: "d6", Secret: true}
+		expectRoll(t, bob)
+	}
+	bob.RollRequestChan <- RollRequest{Expression: "d6", Secret: true}
+	want := fmt.Sprintf("There can't be more than %d unrevealed secret rolls", maxSecrets)
+	if message := expect(t, bob, "error").Payload; message != want {
+		t.Errorf("got error %v", message)
+	}
+}
@@ -0,0 +1,299 @@
+package rooms
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	// LogSettings records the settings in effect for the following entries
+	LogSettings = "settings"
+	// LogRoll records a roll request
+	LogRoll = "roll"
+	// LogTable records a roll on a single random table
+	LogTable = "table"
+	// LogShuffle records shuffling a pile of cards
+	LogShuffle = "shuffle"
+	// LogMacro records a run of a macro
+	LogMacro = "macro"
+
+	// maxLogEntries limits the log of a seeded room. Rolls after that can't be replayed
+	maxLogEntries = 10000
+)
+
+// ErrNotSeeded is returned when asking for the log of a room without a seed
+var ErrNotSeeded = errors.New("Room is not seeded")
+
+// LogEntry is a single use of the randomness of a seeded room
+type LogEntry struct {
+	Type     string        `json:"type"`
+	Date     time.Time     `json:"date"`
+	RollerID string        `json:"rollerId,omitempty"`
+	Settings *RoomSettings `json:"settings,omitempty"`
+	Roll     *RollRequest  `json:"roll,omitempty"`
+	// TableName and TableHash identify the rolled table and its version in RollLog.Tables
+	TableName string     `json:"tableName,omitempty"`
+	TableHash string     `json:"tableHash,omitempty"`
+	Macro     *MacroCall `json:"macro,omitempty"`
+	// Cards is the number of shuffled cards
+	Cards   int          `json:"cards,omitempty"`
+	Results []RollResult `json:"results,omitempty"`
+}
+
+// RollLog records everything which used the randomness of a seeded room in order
+type RollLog struct {
+	Seed    int64      `json:"seed"`
+	Entries []LogEntry `json:"entries"`
+	// Tables holds every version of a table which has been rolled on by its hash
+	Tables map[string]Table `json:"tables,omitempty"`
+	// Truncated is set once the log is full. Entries after that are missing
+	Truncated bool `json:"truncated,omitempty"`
+	// MacroRuns counts the runs of macros. See MacroCall
+	MacroRuns uint64 `json:"macroRuns,omitempty"`
+}
+
+// countingSource counts the numbers drawn so a restored room can continue where it stopped
+type countingSource struct {
+	src   rand.Source
+	draws uint64
+}
+
+func (s *countingSource) Int63() int64 {
+	s.draws++
+	return s.src.Int63()
+}
+
+func (s *countingSource) Seed(seed int64) {
+	s.draws = 0
+	s.src.Seed(seed)
+}
+
+// dicer owns the randomness of a room. Rollers resolve their rolls concurrently so every use is serialized.
+// Seeded rooms log every use in order so the results can be replayed
+type dicer struct {
+	mutex  sync.Mutex
+	source *countingSource
+	rng    *rand.Rand
+	// log is nil if the room is not seeded
+	log *RollLog
+}
+
+// newDicer creates the dicer of a room. Without a seed it is seeded by the clock. draws fast forwards a restored
+// seeded room to where it stopped
+func newDicer(seed *int64, log *RollLog, draws uint64) *dicer {
+	d := &dicer{}
+	if seed == nil {
+		d.source = &countingSource{src: rand.NewSource(time.Now().UnixNano())}
+		d.rng = rand.New(d.source)
+		return d
+	}
+	d.source = &countingSource{src: rand.NewSource(*seed)}
+	for d.source.draws < draws {
+		d.source.Int63()
+	}
+	d.rng = rand.New(d.source)
+	if log == nil || log.Seed != *seed {
+		log = &RollLog{Seed: *seed, Entries: make([]LogEntry, 0)}
+	}
+	d.log = log
+	return d
+}
+
+func (d *dicer) seeded() bool {
+	return d.log != nil
+}
+
+// use hands out the random generator. The returned entry is logged in seeded rooms. nil logs nothing
+func (d *dicer) use(f func(rng *rand.Rand) *LogEntry) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	entry := f(d.rng)
+	if entry != nil {
+		d.append(*entry)
+	}
+}
+
+// macroRand returns the generator for a run of a macro. In seeded rooms it is seeded by the room seed and the number
+// of the run. The macro may take a while and rolls without holding up everybody else
+func (d *dicer) macroRand() (*rand.Rand, uint64) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.log == nil {
+		return rand.New(rand.NewSource(d.rng.Int63())), 0
+	}
+	run := d.log.MacroRuns
+	d.log.MacroRuns++
+	return rand.New(rand.NewSource(macroSeed(d.log.Seed, run))), run
+}
+
+// macroSeed derives the seed of a macro run from the room seed using splitmix64 so neighbouring runs get unrelated
+// seeds
+func macroSeed(seed int64, run uint64) int64 {
+	z := uint64(seed) + (run+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
+
+// tableHash identifies the version of a table
+func tableHash(table Table) string {
+	data, _ := json.Marshal(table)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// logTable keeps a table in the log of a seeded room so entries can reference it by the returned hash. Every version
+// is stored only once
+func (d *dicer) logTable(table Table) string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.log == nil || len(d.log.Entries) >= maxLogEntries {
+		return ""
+	}
+	hash := tableHash(table)
+	if d.log.Tables == nil {
+		d.log.Tables = make(map[string]Table)
+	}
+	if _, ok := d.log.Tables[hash]; !ok {
+		d.log.Tables[hash] = table
+	}
+	return hash
+}
+
+// record logs an entry which doesn't need the random generator
+func (d *dicer) record(entry LogEntry) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.append(entry)
+}
+
+func (d *dicer) append(entry LogEntry) {
+	if d.log == nil {
+		return
+	}
+	if len(d.log.Entries) >= maxLogEntries {
+		d.log.Truncated = true
+		return
+	}
+	entry.Date = time.Now()
+	d.log.Entries = append(d.log.Entries, entry)
+}
+
+// snapshot returns a copy of the log and the number of drawn random numbers
+func (d *dicer) snapshot() (*RollLog, uint64) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.log == nil {
+		return nil, 0
+	}
+	log := *d.log
+	log.Entries = append([]LogEntry(nil), d.log.Entries...)
+	if d.log.Tables != nil {
+		log.Tables = make(map[string]Table, len(d.log.Tables))
+		for hash, table := range d.log.Tables {
+			log.Tables[hash] = table
+		}
+	}
+	return &log, d.source.draws
+}
+
+// RollLog returns the log of a seeded room. token has to be the resume token of the owner
+func (m *Manager) RollLog(roomName string, token string) (RollLog, error) {
+	var log *RollLog
+	var logErr error
+	err := m.inRoom(roomName, func(r *roomState) {
+		if !r.isOwnerToken(token) {
+			logErr = ErrNotOwner
+			return
+		}
+		log, _ = r.dice.snapshot()
+		if log == nil {
+			logErr = ErrNotSeeded
+		}
+	})
+	if err != nil {
+		return RollLog{}, err
+	}
+	if logErr != nil {
+		return RollLog{}, logErr
+	}
+	return *log, nil
+}
+
+// Replayed is a log entry together with the results regenerated from the seed
+type Replayed struct {
+	Entry   LogEntry
+	Results []RollResult
+	// Matches is true if the regenerated results are the logged ones
+	Matches bool
+}
+
+// Replay regenerates all results of a log from its seed. The log has to start with the settings of the room
+func Replay(log RollLog) ([]Replayed, error) {
+	if len(log.Entries) == 0 || log.Entries[0].Type != LogSettings {
+		return nil, errors.New("The log has to start with the room settings")
+	}
+	seed := log.Seed
+	d := newDicer(&seed, nil, 0)
+	var settings RoomSettings
+	replayed := make([]Replayed, 0, len(log.Entries))
+	for i, entry := range log.Entries {
+		var results []RollResult
+		switch entry.Type {
+		case LogSettings:
+			if entry.Settings == nil {
+				return replayed, fmt.Errorf("Entry %d: missing settings", i)
+			}
+			settings = *entry.Settings
+		case LogRoll:
+			if entry.Roll == nil {
+				return replayed, fmt.Errorf("Entry %d: missing roll", i)
+			}
+			roll, err := resolve(settings, 0, d.rng, *entry.Roll)
+			if err != nil {
+				return replayed, fmt.Errorf("Entry %d: %v", i, err)
+			}
+			results = roll.Results
+		case LogTable:
+			table, ok := log.Tables[entry.TableHash]
+			if !ok {
+				return replayed, fmt.Errorf("Entry %d: missing table %s", i, entry.TableName)
+			}
+			if err := table.Validate(); err != nil {
+				return replayed, fmt.Errorf("Entry %d: %v", i, err)
+			}
+			result, _ := table.roll(d.rng)
+			results = []RollResult{result}
+		case LogMacro:
+			if entry.Macro == nil {
+				return replayed, fmt.Errorf("Entry %d: missing macro", i)
+			}
+			// the settings are logged with the run as they may have changed while it was running
+			macroSettings := settings
+			if entry.Settings != nil {
+				macroSettings = *entry.Settings
+			}
+			rng := rand.New(rand.NewSource(macroSeed(log.Seed, entry.Macro.Run)))
+			// failed runs are logged as well. only the dice matter
+			roll, _ := runMacro(macroSettings, entry.Macro.MaxDice, rng, *entry.Macro)
+			results = roll.Results
+		case LogShuffle:
+			d.rng.Shuffle(entry.Cards, func(i, j int) {})
+		default:
+			return replayed, fmt.Errorf("Entry %d: unknown type %s", i, entry.Type)
+		}
+		replayed = append(replayed, Replayed{
+			Entry:   entry,
+			Results: results,
+			Matches: len(results) == len(entry.Results) && (len(results) == 0 || reflect.DeepEqual(results, entry.Results)),
+		})
+	}
+	return replayed, nil
+}
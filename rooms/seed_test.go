@@ -0,0 +1,117 @@
+package rooms
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// seededLog rolls like a seeded room and returns its log
+func seededLog(t *testing.T, seed int64) RollLog {
+	t.Helper()
+	d := newDicer(&seed, nil, 0)
+	settings := testSettings()
+	d.record(LogEntry{Type: LogSettings, Settings: &settings})
+
+	roll := func(request RollRequest) {
+		d.use(func(rng *rand.Rand) *LogEntry {
+			results, err := resolve(settings, 50, rng, request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return &LogEntry{Type: LogRoll, Roll: &request, Results: results.Results}
+		})
+	}
+	roll(RollRequest{Expression: "4d6kh3+2"})
+	roll(RollRequest{Dice: []string{"d20", "d8"}})
+	roll(RollRequest{Expression: "d20", Mode: ModeAdvantage})
+
+	table := Table{Entries: []TableEntry{{Weight: 1, Result: "a"}, {Weight: 5, Result: "b"}}}
+	for i := 0; i < 2; i++ {
+		hash := d.logTable(table)
+		d.use(func(rng *rand.Rand) *LogEntry {
+			result, _ := table.roll(rng)
+			return &LogEntry{Type: LogTable, TableName: "test", TableHash: hash, Results: []RollResult{result}}
+		})
+	}
+
+	cards := make([]Card, 52)
+	d.use(func(rng *rand.Rand) *LogEntry {
+		rng.Shuffle(len(cards), func(i, j int) {
+			cards[i], cards[j] = cards[j], cards[i]
+		})
+		return &LogEntry{Type: LogShuffle, Cards: len(cards)}
+	})
+
+	changed := settings
+	changed.System = SystemD20
+	d.record(LogEntry{Type: LogSettings, Settings: &changed})
+	settings = changed
+	roll(RollRequest{Expression: "d20+3 dis"})
+
+	rng, run := d.macroRand()
+	call := MacroCall{Name: "test", Source: "r = roll(\"3d6\")\nprint(r.total)\n", MaxDice: 50, Run: run}
+	results, err := runMacro(settings, call.MaxDice, rng, call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.record(LogEntry{Type: LogMacro, Settings: &settings, Macro: &call, Results: results.Results})
+	roll(RollRequest{Expression: "2d20"})
+
+	log, _ := d.snapshot()
+	return *log
+}
+
+func TestReplay(t *testing.T) {
+	log := seededLog(t, 42)
+	replayed, err := Replay(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != len(log.Entries) {
+		t.Fatalf("replayed %d of %d entries", len(replayed), len(log.Entries))
+	}
+	for i, entry := range replayed {
+		if !entry.Matches {
+			t.Errorf("entry %d of type %s got %+v, logged %+v", i, entry.Entry.Type, entry.Results, entry.Entry.Results)
+		}
+	}
+	// rolling twice on the same table stores it once
+	if len(log.Tables) != 1 {
+		t.Errorf("got %d tables", len(log.Tables))
+	}
+
+	// the log of another seed doesn't match
+	other := seededLog(t, 43)
+	replayed, err = Replay(RollLog{Seed: log.Seed, Entries: other.Entries, Tables: other.Tables})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed[1].Matches {
+		t.Error("rolls of another seed shouldn't match")
+	}
+}
+
+func TestReplayInvalid(t *testing.T) {
+	settings := testSettings()
+	invalid := Table{Entries: []TableEntry{{Weight: int(^uint(0) >> 1), Result: "a"}, {Weight: 1, Result: "b"}}}
+	tables := map[string]Table{tableHash(invalid): invalid}
+	tests := []struct {
+		name    string
+		entries []LogEntry
+	}{
+		{name: "empty"},
+		{name: "no settings", entries: []LogEntry{{Type: LogRoll, Roll: &RollRequest{Expression: "d6"}}}},
+		{name: "missing roll", entries: []LogEntry{{Type: LogSettings, Settings: &settings}, {Type: LogRoll}}},
+		{name: "invalid roll", entries: []LogEntry{{Type: LogSettings, Settings: &settings}, {Type: LogRoll, Roll: &RollRequest{Expression: "d6+"}}}},
+		{name: "missing table", entries: []LogEntry{{Type: LogSettings, Settings: &settings}, {Type: LogTable, TableName: "gone", TableHash: "00"}}},
+		{name: "invalid table", entries: []LogEntry{{Type: LogSettings, Settings: &settings}, {Type: LogTable, TableHash: tableHash(invalid)}}},
+		{name: "unknown type", entries: []LogEntry{{Type: LogSettings, Settings: &settings}, {Type: "dance"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Replay(RollLog{Seed: 1, Entries: test.entries, Tables: tables}); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package rooms
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+const (
+	// FeatureModifiers allows adding numbers to a roll like d20+5
+	FeatureModifiers = "modifiers"
+	// FeatureSubtract allows subtracting dice like d20-d6
+	FeatureSubtract = "subtract"
+	// FeatureKeep allows keeping the highest or lowest dice like 4d6kh3
+	FeatureKeep = "keep"
+	// FeatureSuccesses allows counting the dice meeting a target like 6d10>=8
+	FeatureSuccesses = "successes"
+	// FeatureModes allows advantage, disadvantage, best and worst of
+	FeatureModes = "modes"
+)
+
+// Features are all expression features a room may restrict
+var Features = []string{FeatureModifiers, FeatureSubtract, FeatureKeep, FeatureSuccesses, FeatureModes}
+
+// RoomSettings are chosen when creating a room. The owner may change them later on
+type RoomSettings struct {
+	AllowSpectators bool `json:"allowSpectators"`
+	// System is the name of the game system resolving the rolls
+	System string `json:"system"`
+	// AllowedDice are the builtin dice which may be rolled. If empty every builtin die is allowed
+	AllowedDice []string `json:"allowedDice"`
+	// Dice are custom dice available in addition to the builtin ones. They are always allowed
+	Dice []Die `json:"dice"`
+	// Features are the expression features which may be used. If empty every feature is allowed
+	Features []string `json:"features"`
+	// Criticals maps die names to their critical results. They take precedence over the criticals of custom dice
+	Criticals map[string]Criticals `json:"criticals"`
+	// TieRule decides contests with equal totals: draw, challenger, opponent or reroll
+	TieRule string `json:"tieRule"`
+	// Seed makes the rolls of the room reproducible. It can only be chosen when creating the room and is never
+	// shown to the rollers
+	Seed *int64 `json:"seed,omitempty"`
+	// TurnsOnly restricts public rolls to whoever has the turn and the GM
+	TurnsOnly bool `json:"turnsOnly"`
+}
+
+// UpdateSettingsRequest asks the room to replace its settings. Only the owner may change them
+type UpdateSettingsRequest struct {
+	RoomSettings
+}
+
+// RoomInfo is sent to rollers on join and whenever the settings change
+type RoomInfo struct {
+	Name     string       `json:"name"`
+	Settings RoomSettings `json:"settings"`
+	// Seeded rooms roll a reproducible sequence
+	Seeded bool `json:"seeded"`
+	// BuiltinDice are the builtin dice the owner may choose from for AllowedDice. Other numbered dice like d30 may
+	// be rolled as well if the room doesn't restrict the dice
+	BuiltinDice []string `json:"builtinDice"`
+	// Dice are the dice of the room: the allowed builtin dice and the custom dice
+	Dice []string `json:"dice"`
+	// Features are all expression features. The allowed ones are part of the settings
+	Features []string `json:"features"`
+}
+
+// DefaultSettings returns the settings of a room if nothing else has been chosen
+func DefaultSettings() RoomSettings {
+	return RoomSettings{
+		AllowSpectators: true,
+		System:          SystemGeneric,
+		TieRule:         TieDraw,
+		AllowedDice:     []string{"d4", "d6", "d8", "d10", "d12", "d20", "d100"},
+		Dice:            []Die{},
+		Features:        []string{},
+		Criticals: map[string]Criticals{
+			"d20": {Success: []int{20}, Failure: []int{1}},
+		},
+	}
+}
+
+// Validate checks the settings
+func (s RoomSettings) Validate() error {
+	if _, err := lookupSystem(s.System); err != nil {
+		return err
+	}
+	if !validTieRule(s.TieRule) {
+		return fmt.Errorf("Unknown tie rule %s", s.TieRule)
+	}
+	if err := validateDice(s.Dice); err != nil {
+		return err
+	}
+	if len(s.Criticals) > maxCustomDice {
+		return fmt.Errorf("Only %d dice may have their own criticals", maxCustomDice)
+	}
+	for name, criticals := range s.Criticals {
+		if len(name) > maxDieNameLength {
+			return fmt.Errorf("Die names must have between 1 and %d characters", maxDieNameLength)
+		}
+		if err := criticals.Validate(); err != nil {
+			return err
+		}
+	}
+	if len(s.AllowedDice) > maxAllowedDice {
+		return fmt.Errorf("A room can't allow more than %d dice", maxAllowedDice)
+	}
+	allowed := make(map[string]bool, len(s.AllowedDice))
+	for _, name := range s.AllowedDice {
+		if _, err := builtinDie(name); err != nil {
+			return err
+		}
+		if allowed[name] {
+			return errors.New("Allowed dice must be unique")
+		}
+		allowed[name] = true
+	}
+	features := make(map[string]bool, len(s.Features))
+	for _, feature := range s.Features {
+		known := false
+		for _, f := range Features {
+			known = known || f == feature
+		}
+		if !known {
+			return fmt.Errorf("Unknown feature %s", feature)
+		}
+		if features[feature] {
+			return errors.New("Features must be unique")
+		}
+		features[feature] = true
+	}
+	return nil
+}
+
+// allows checks whether an expression feature may be used in the room
+func (s RoomSettings) allows(feature string) error {
+	if len(s.Features) == 0 {
+		return nil
+	}
+	for _, allowed := range s.Features {
+		if allowed == feature {
+			return nil
+		}
+	}
+	return fmt.Errorf("The feature %s is not allowed in this room", feature)
+}
+
+// diceNames are the dice of the room offered to the rollers
+func (s RoomSettings) diceNames() []string {
+	names := make([]string, 0, len(builtinDice)+len(s.Dice))
+	if len(s.AllowedDice) > 0 {
+		names = append(names, s.AllowedDice...)
+	} else {
+		names = append(names, builtinDice...)
+	}
+	for _, die := range s.Dice {
+		names = append(names, die.Name)
+	}
+	return names
+}
+
+// die looks up a die which may be rolled in the room
+func (s RoomSettings) die(name string) (Die, error) {
+	for _, die := range s.Dice {
+		if die.Name == name {
+			return die, nil
+		}
+	}
+	if len(s.AllowedDice) > 0 {
+		allowed := false
+		for _, allowedName := range s.AllowedDice {
+			if allowedName == name {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return Die{}, fmt.Errorf("%s is not allowed in this room", name)
+		}
+	}
+	return builtinDie(name)
+}
+
+// criticals returns the critical results of a die
+func (s RoomSettings) criticals(die Die) *Criticals {
+	if criticals, ok := s.Criticals[die.Name]; ok {
+		return &criticals
+	}
+	return die.Criticals
+}
+
+// sharedSettings are changed by the room goroutine and read by the roller goroutines
+type sharedSettings struct {
+	mutex    sync.RWMutex
+	settings RoomSettings
+}
+
+func (s *sharedSettings) get() RoomSettings {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.settings
+}
+
+func (s *sharedSettings) set(settings RoomSettings) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.settings = settings
+}
+
+func (r *roomState) roomInfo() Event {
+	settings := r.settings.get()
+	// knowing the seed would allow predicting every roll
+	settings.Seed = nil
+	return Event{Type: "roominfo", Payload: RoomInfo{
+		Name:        r.name,
+		Settings:    settings,
+		Seeded:      r.dice.seeded(),
+		BuiltinDice: builtinDice,
+		Dice:        settings.diceNames(),
+		Features:    Features,
+	}}
+}
+
+func (r *roomState) updateSettings(from Roller, req UpdateSettingsRequest) {
+	if from.ID != r.owner {
+		r.sendError(from, "Only the owner may change the settings")
+		return
+	}
+	if err := req.RoomSettings.Validate(); err != nil {
+		r.sendError(from, err.Error())
+		return
+	}
+	current := r.settings.get()
+	if req.System != current.System {
+		r.sendError(from, "The game system can only be chosen when creating the room")
+		return
+	}
+	req.Seed = current.Seed
+	// no roll may slip in between changing and logging the settings
+	r.dice.use(func(*rand.Rand) *LogEntry {
+		r.settings.set(req.RoomSettings)
+		return &LogEntry{Type: LogSettings, Settings: &req.RoomSettings}
+	})
+	r.log.Infof("%s changed the settings", from.ID)
+	info := r.roomInfo()
+	for _, roller := range r.rollers {
+		r.sendEvent(roller, info)
+	}
+}
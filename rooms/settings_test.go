@@ -0,0 +1,139 @@
+package rooms
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSettingsValidate(t *testing.T) {
+	manyDice := make([]Die, maxCustomDice+1)
+	for i := range manyDice {
+		manyDice[i] = Die{Name: fmt.Sprintf("die%d", i), Sides: 6}
+	}
+	manyCriticals := make(map[string]Criticals, maxCustomDice+1)
+	for i := 0; i <= maxCustomDice; i++ {
+		manyCriticals[fmt.Sprintf("d%d", i+2)] = Criticals{Success: []int{1}}
+	}
+	manyAllowed := make([]string, maxAllowedDice+1)
+	for i := range manyAllowed {
+		manyAllowed[i] = fmt.Sprintf("d%d", i+2)
+	}
+	tests := []struct {
+		name    string
+		change  func(settings *RoomSettings)
+		wantErr bool
+	}{
+		{name: "default", change: func(settings *RoomSettings) {}},
+		{name: "custom die", change: func(settings *RoomSettings) {
+			settings.Dice = []Die{{Name: "boost", Faces: []Face{{Value: 0}, {Value: 1, Label: "Success"}}}}
+		}},
+		{name: "unknown system", change: func(settings *RoomSettings) { settings.System = "chess" }, wantErr: true},
+		{name: "unknown tie rule", change: func(settings *RoomSettings) { settings.TieRule = "coin" }, wantErr: true},
+		{name: "too many dice", change: func(settings *RoomSettings) { settings.Dice = manyDice }, wantErr: true},
+		{name: "duplicate dice", change: func(settings *RoomSettings) {
+			settings.Dice = []Die{{Name: "x", Sides: 6}, {Name: "x", Sides: 8}}
+		}, wantErr: true},
+		{name: "long label", change: func(settings *RoomSettings) {
+			settings.Dice = []Die{{Name: "x", Faces: []Face{{Label: strings.Repeat("x", maxLabelLength+1)}}}}
+		}, wantErr: true},
+		{name: "too many criticals", change: func(settings *RoomSettings) { settings.Criticals = manyCriticals }, wantErr: true},
+		{name: "long critical name", change: func(settings *RoomSettings) {
+			settings.Criticals = map[string]Criticals{strings.Repeat("d", maxDieNameLength+1): {}}
+		}, wantErr: true},
+		{name: "too many allowed dice", change: func(settings *RoomSettings) { settings.AllowedDice = manyAllowed }, wantErr: true},
+		{name: "unknown allowed die", change: func(settings *RoomSettings) { settings.AllowedDice = []string{"d1"} }, wantErr: true},
+		{name: "duplicate allowed dice", change: func(settings *RoomSettings) {
+			settings.AllowedDice = []string{"d6", "d6"}
+		}, wantErr: true},
+		{name: "features", change: func(settings *RoomSettings) { settings.Features = []string{FeatureKeep, FeatureModes} }},
+		{name: "unknown feature", change: func(settings *RoomSettings) { settings.Features = []string{"explode"} }, wantErr: true},
+		{name: "duplicate features", change: func(settings *RoomSettings) {
+			settings.Features = []string{FeatureKeep, FeatureKeep}
+		}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := DefaultSettings()
+			test.change(&settings)
+			err := settings.Validate()
+			if test.wantErr && err == nil {
+				t.Error("expected an error")
+			}
+			if !test.wantErr && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestSettingsFeatures(t *testing.T) {
+	tests := []struct {
+		feature string
+		system  string
+		request RollRequest
+	}{
+		{feature: FeatureModifiers, request: RollRequest{Expression: "d20+5"}},
+		{feature: FeatureModifiers, request: RollRequest{Expression: "d20-1"}},
+		{feature: FeatureSubtract, request: RollRequest{Expression: "d20-d6"}},
+		{feature: FeatureKeep, request: RollRequest{Expression: "4d6kh3"}},
+		{feature: FeatureSuccesses, request: RollRequest{Expression: "6d10>=8"}},
+		{feature: FeatureModes, request: RollRequest{Expression: "d20", Mode: ModeAdvantage}},
+		{feature: FeatureModes, request: RollRequest{Dice: []string{"d6"}, Mode: ModeBest, Times: 3}},
+		{feature: FeatureModes, system: SystemD20, request: RollRequest{Expression: "d20 adv"}},
+	}
+	for _, test := range tests {
+		t.Run(test.feature+" "+test.request.Expression, func(t *testing.T) {
+			settings := DefaultSettings()
+			if test.system != "" {
+				settings.System = test.system
+			}
+			rng := rand.New(rand.NewSource(1))
+			if _, err := resolve(settings, 0, rng, test.request); err != nil {
+				t.Fatalf("all features are allowed by default: %v", err)
+			}
+			for _, feature := range Features {
+				if feature != test.feature {
+					settings.Features = append(settings.Features, feature)
+				}
+			}
+			if _, err := resolve(settings, 0, rng, test.request); err == nil {
+				t.Errorf("%s isn't allowed", test.feature)
+			}
+			settings.Features = append(settings.Features, test.feature)
+			if _, err := resolve(settings, 0, rng, test.request); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestRoomInfoDice(t *testing.T) {
+	m := newTestManager(t, testConfig(), nil)
+	settings := DefaultSettings()
+	settings.AllowedDice = []string{"d6", "d20"}
+	settings.Dice = []Die{{Name: "boost", Sides: 6}}
+	name, err := m.CreateRoom("dice", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roller, err := m.AddRoller(name, JoinRequest{Name: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := expect(t, roller, "roominfo").Payload.(RoomInfo)
+	if !reflect.DeepEqual(info.Dice, []string{"d6", "d20", "boost"}) || !reflect.DeepEqual(info.BuiltinDice, builtinDice) ||
+		!reflect.DeepEqual(info.Features, Features) {
+		t.Errorf("got %+v", info)
+	}
+
+	// every builtin die may be rolled without a restriction
+	settings.AllowedDice = nil
+	roller.Requests <- &UpdateSettingsRequest{RoomSettings: settings}
+	info = expect(t, roller, "roominfo").Payload.(RoomInfo)
+	if want := append(append([]string{}, builtinDice...), "boost"); !reflect.DeepEqual(info.Dice, want) {
+		t.Errorf("got dice %v, want %v", info.Dice, want)
+	}
+}
@@ -0,0 +1,182 @@
+package rooms
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Snapshot is the state of a room that survives a server restart
+type Snapshot struct {
+	Name     string              `json:"name"`
+	Settings RoomSettings        `json:"settings"`
+	Created  time.Time           `json:"created"`
+	History  []RollResults       `json:"history"`
+	Members  []UserInfo          `json:"members"`
+	Stats    RoomStats           `json:"stats"`
+	Owner    string              `json:"owner"`
+	Sessions map[string]UserInfo `json:"sessions"`
+	Bans     Bans                `json:"bans"`
+	Decks    map[string]*Deck    `json:"decks"`
+	Tables   map[string]Table    `json:"tables"`
+	Macros   map[string]string   `json:"macros"`
+	NPCs     []UserInfo          `json:"npcs"`
+	Secrets  []RollResults       `json:"secrets"`
+	Trackers []Tracker           `json:"trackers"`
+	Turns    []string            `json:"turns"`
+	Turn     int                 `json:"turn"`
+	// Log and Draws let seeded rooms continue their sequence of rolls
+	Log   *RollLog `json:"log,omitempty"`
+	Draws uint64   `json:"draws,omitempty"`
+}
+
+// Storage persists rooms and the archives of closed rooms between server restarts
+type Storage interface {
+	Save(snapshot Snapshot) error
+	Delete(roomName string) error
+	Load() ([]Snapshot, error)
+	SaveArchive(archive Archive) error
+	DeleteArchive(roomName string) error
+	LoadArchives() ([]Archive, error)
+}
+
+// DirStorage stores every room as a JSON file in a directory. Archives are stored in its subdirectory archives
+type DirStorage struct {
+	dir string
+	log *log.Logger
+}
+
+// NewDirStorage creates a new DirStorage. The directory will be created on first write. Files which can't be loaded
+// are logged and skipped
+func NewDirStorage(dir string, log *log.Logger) *DirStorage {
+	return &DirStorage{
+		dir: dir,
+		log: log,
+	}
+}
+
+func (s *DirStorage) archiveDir() string {
+	return filepath.Join(s.dir, "archives")
+}
+
+// room names may contain anything so hash them to get a safe filename
+func filename(dir string, roomName string) string {
+	sum := sha256.Sum256([]byte(roomName))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func writeFile(dir string, name string, v interface{}) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	path := filename(dir, name)
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// removeFile deletes a stored file. Deleting a non existing file is not an error
+func removeFile(dir string, name string) error {
+	err := os.Remove(filename(dir, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// readFiles calls read with the content of every stored file in dir. A file which can't be read is logged and skipped
+// so that it doesn't take all other files with it
+func (s *DirStorage) readFiles(dir string, read func(data []byte) error) error {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err == nil {
+			err = read(data)
+		}
+		if err != nil {
+			s.log.Errorf("Skipping %s: %v", filepath.Join(dir, file.Name()), err)
+		}
+	}
+	return nil
+}
+
+// Save writes a room snapshot
+func (s *DirStorage) Save(snapshot Snapshot) error {
+	return writeFile(s.dir, snapshot.Name, &snapshot)
+}
+
+// Delete removes a room snapshot. Deleting a non existing room is not an error
+func (s *DirStorage) Delete(roomName string) error {
+	return removeFile(s.dir, roomName)
+}
+
+// Load reads all stored snapshots
+func (s *DirStorage) Load() ([]Snapshot, error) {
+	var snapshots []Snapshot
+	err := s.readFiles(s.dir, func(data []byte) error {
+		// settings missing in older snapshots keep their defaults
+		snapshot := Snapshot{Settings: DefaultSettings()}
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return fmt.Errorf("Invalid snapshot: %v", err)
+		}
+		if snapshot.Name == "" {
+			return errors.New("The snapshot has no room name")
+		}
+		snapshots = append(snapshots, snapshot)
+		return nil
+	})
+	return snapshots, err
+}
+
+// SaveArchive writes the archive of a closed room
+func (s *DirStorage) SaveArchive(archive Archive) error {
+	return writeFile(s.archiveDir(), archive.Name, &archive)
+}
+
+// DeleteArchive removes an archive. Deleting a non existing archive is not an error
+func (s *DirStorage) DeleteArchive(roomName string) error {
+	return removeFile(s.archiveDir(), roomName)
+}
+
+// LoadArchives reads all stored archives
+func (s *DirStorage) LoadArchives() ([]Archive, error) {
+	var archives []Archive
+	err := s.readFiles(s.archiveDir(), func(data []byte) error {
+		var archive Archive
+		if err := json.Unmarshal(data, &archive); err != nil {
+			return fmt.Errorf("Invalid archive: %v", err)
+		}
+		if archive.Name == "" {
+			return errors.New("The archive has no room name")
+		}
+		archives = append(archives, archive)
+		return nil
+	})
+	return archives, err
+}
@@ -0,0 +1,103 @@
+package rooms
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func newTestDirStorage(t *testing.T) (*DirStorage, *logtest.Hook) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "wuerfler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	logger, hook := logtest.NewNullLogger()
+	return NewDirStorage(dir, logger), hook
+}
+
+func TestDirStorage(t *testing.T) {
+	s, hook := newTestDirStorage(t)
+	// nothing has been written yet
+	if snapshots, err := s.Load(); err != nil || len(snapshots) != 0 {
+		t.Fatalf("got %+v, %v", snapshots, err)
+	}
+	if archives, err := s.LoadArchives(); err != nil || len(archives) != 0 {
+		t.Fatalf("got %+v, %v", archives, err)
+	}
+
+	for _, name := range []string{"../escape", "tavern"} {
+		if err := s.Save(Snapshot{Name: name, Settings: DefaultSettings(), Owner: "owner"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveArchive(Archive{Name: "closed", Closed: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	// neither a corrupt file nor one without a room name stops the others from loading
+	for path, content := range map[string]string{
+		filepath.Join(s.dir, "corrupt.json"):          `{"name": "tavern`,
+		filepath.Join(s.dir, "nameless.json"):         `{"owner": "owner"}`,
+		filepath.Join(s.dir, "old.json"):              `{"name": "old", "owner": "owner"}`,
+		filepath.Join(s.archiveDir(), "corrupt.json"): `[]`,
+	} {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshots, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, snapshot := range snapshots {
+		names[snapshot.Name] = true
+		// settings missing in older snapshots keep their defaults
+		if snapshot.Owner != "owner" || snapshot.Settings.System != SystemGeneric || !snapshot.Settings.AllowSpectators {
+			t.Errorf("got %+v", snapshot)
+		}
+	}
+	if len(snapshots) != 3 || !names["../escape"] || !names["tavern"] || !names["old"] {
+		t.Errorf("got %+v", snapshots)
+	}
+	archives, err := s.LoadArchives()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 1 || archives[0].Name != "closed" {
+		t.Errorf("got %+v", archives)
+	}
+	if len(hook.AllEntries()) != 3 {
+		t.Errorf("expected 3 skipped files, got %+v", hook.AllEntries())
+	}
+
+	for _, name := range []string{"tavern", "missing"} {
+		if err := s.Delete(name); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DeleteArchive(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.DeleteArchive("closed"); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, _ = s.Load()
+	for _, snapshot := range snapshots {
+		if snapshot.Name == "tavern" {
+			t.Error("tavern hasn't been deleted")
+		}
+	}
+	if len(snapshots) != 2 {
+		t.Errorf("got %+v", snapshots)
+	}
+	if archives, _ := s.LoadArchives(); len(archives) != 0 {
+		t.Errorf("got %+v", archives)
+	}
+}
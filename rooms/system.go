@@ -0,0 +1,308 @@
+package rooms
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxDiceCount limits the dice of a single term independent of the configured dice limit. Counts this high are only
+// ever typos or attempts to overflow the dice count
+const maxDiceCount = 10000
+
+// maxInt is the largest int. DiceCount saturates there instead of overflowing
+const maxInt = int(^uint(0) >> 1)
+
+// GameSystem implements the dice rules of a role playing game
+type GameSystem interface {
+	// Name identifies the system in the room settings
+	Name() string
+	// ParseRoll turns a roll request into the dice which have to be rolled
+	ParseRoll(request RollRequest, settings RoomSettings) (Roll, error)
+	// Resolve rolls the dice
+	Resolve(roll Roll, rng *rand.Rand) []RollResult
+	// Describe explains the outcome like "Total 17" or "3 successes"
+	Describe(roll Roll, results []RollResult) string
+}
+
+// DiceGroup are equal dice rolled together like 4d6kh3
+type DiceGroup struct {
+	Count int
+	Die   Die
+	// Negative groups are subtracted from the total
+	Negative bool
+	// KeepHighest or KeepLowest dice of the group. All dice are kept if both are 0
+	KeepHighest int
+	KeepLowest  int
+	// Target is the minimum value of a success in dice pools. 0 if not set
+	Target int
+}
+
+// score is what a single die counts. In dice pools with a target it is 1 for a success and 0 otherwise
+func (group DiceGroup) score(result int) int {
+	if group.Target == 0 {
+		return result
+	}
+	if result >= group.Target {
+		return 1
+	}
+	return 0
+}
+
+// Roll is a parsed roll request
+type Roll struct {
+	Groups   []DiceGroup
+	Modifier int
+}
+
+var systemsMutex sync.RWMutex
+var systems = make(map[string]GameSystem)
+
+// RegisterSystem makes a game system selectable for rooms
+func RegisterSystem(system GameSystem) {
+	systemsMutex.Lock()
+	defer systemsMutex.Unlock()
+	systems[system.Name()] = system
+}
+
+// Systems returns the names of all registered game systems
+func Systems() []string {
+	systemsMutex.RLock()
+	defer systemsMutex.RUnlock()
+	names := make([]string, 0, len(systems))
+	for name := range systems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupSystem(name string) (GameSystem, error) {
+	systemsMutex.RLock()
+	defer systemsMutex.RUnlock()
+	system, ok := systems[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown game system %s", name)
+	}
+	return system, nil
+}
+
+// DiceCount is the number of dice which will be rolled. It doesn't overflow but stops at the largest int
+func (roll Roll) DiceCount() int {
+	count := 0
+	for _, group := range roll.Groups {
+		if group.Count > maxInt-count {
+			return maxInt
+		}
+		count += group.Count
+	}
+	return count
+}
+
+// Total sums up the scores of the kept dice and the modifier
+func (roll Roll) Total(results []RollResult) int {
+	total := roll.Modifier
+	roll.eachGroup(results, func(group DiceGroup, groupResults []RollResult) {
+		for _, result := range groupResults {
+			if result.Dropped {
+				continue
+			}
+			if group.Negative {
+				total -= group.score(result.Result)
+			} else {
+				total += group.score(result.Result)
+			}
+		}
+	})
+	return total
+}
+
+// eachGroup calls f with the results belonging to each group
+func (roll Roll) eachGroup(results []RollResult, f func(group DiceGroup, groupResults []RollResult)) {
+	offset := 0
+	for _, group := range roll.Groups {
+		end := offset + group.Count
+		if end > len(results) {
+			end = len(results)
+		}
+		f(group, results[offset:end])
+		offset = end
+	}
+}
+
+// rollDice rolls every group and marks the dice which are not kept as dropped
+func rollDice(roll Roll, rng *rand.Rand) []RollResult {
+	// the count is only a hint. rolls replayed from a log aren't checked against the dice limit
+	capacity := roll.DiceCount()
+	if capacity > maxDiceCount {
+		capacity = maxDiceCount
+	}
+	results := make([]RollResult, 0, capacity)
+	for _, group := range roll.Groups {
+		groupResults := make([]RollResult, group.Count)
+		for i := range groupResults {
+			groupResults[i] = group.Die.roll(rng)
+		}
+		keep(groupResults, group.KeepHighest, group.KeepLowest)
+		results = append(results, groupResults...)
+	}
+	return results
+}
+
+// keep marks all but the highest or lowest results as dropped
+func keep(results []RollResult, highest int, lowest int) {
+	if (highest == 0 && lowest == 0) || highest >= len(results) || lowest >= len(results) {
+		return
+	}
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return results[order[a]].Result > results[order[b]].Result
+	})
+	kept := highest
+	if highest == 0 {
+		// lowest are at the end
+		for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+			order[i], order[j] = order[j], order[i]
+		}
+		kept = lowest
+	}
+	for _, i := range order[kept:] {
+		results[i].Dropped = true
+	}
+}
+
+var termRegexp = regexp.MustCompile(`^(\d*)d(\d+|F|%|\[[^\]]+\])(?:(kh|kl)(\d+))?(?:>=(\d+))?$`)
+
+// parseExpression parses expressions like 4d6kh3+2, 2d20kl1, 4dF-1, 6d10>=8 or 2d[boost]
+func parseExpression(expression string, settings RoomSettings) (Roll, error) {
+	roll := Roll{Groups: make([]DiceGroup, 0)}
+	terms, err := splitTerms(strings.Join(strings.Fields(expression), ""))
+	if err != nil {
+		return roll, err
+	}
+	for _, term := range terms {
+		negative := term[0] == '-'
+		term = term[1:]
+		if modifier, err := strconv.Atoi(term); err == nil {
+			if err := settings.allows(FeatureModifiers); err != nil {
+				return roll, err
+			}
+			if negative {
+				modifier = -modifier
+			}
+			roll.Modifier += modifier
+			continue
+		}
+		match := termRegexp.FindStringSubmatch(term)
+		if match == nil {
+			return roll, fmt.Errorf("Invalid dice %s", term)
+		}
+		if negative {
+			if err := settings.allows(FeatureSubtract); err != nil {
+				return roll, err
+			}
+		}
+		group := DiceGroup{Count: 1, Negative: negative}
+		if match[1] != "" {
+			group.Count, err = strconv.Atoi(match[1])
+			if err != nil || group.Count < 1 {
+				return roll, fmt.Errorf("Invalid dice %s", term)
+			}
+			if group.Count > maxDiceCount {
+				return roll, fmt.Errorf("Can't roll more than %d dice at once", maxDiceCount)
+			}
+		}
+		name := "d" + match[2]
+		switch {
+		case match[2] == "%":
+			name = "d100"
+		case strings.HasPrefix(match[2], "["):
+			name = match[2][1 : len(match[2])-1]
+		}
+		group.Die, err = settings.die(name)
+		if err != nil {
+			return roll, err
+		}
+		if match[3] != "" {
+			if err := settings.allows(FeatureKeep); err != nil {
+				return roll, err
+			}
+			n, _ := strconv.Atoi(match[4])
+			if n < 1 || n > group.Count {
+				return roll, fmt.Errorf("Can't keep %d of %d dice", n, group.Count)
+			}
+			if match[3] == "kh" {
+				group.KeepHighest = n
+			} else {
+				group.KeepLowest = n
+			}
+		}
+		if match[5] != "" {
+			if err := settings.allows(FeatureSuccesses); err != nil {
+				return roll, err
+			}
+			group.Target, _ = strconv.Atoi(match[5])
+		}
+		roll.Groups = append(roll.Groups, group)
+	}
+	return roll, nil
+}
+
+// splitTerms splits an expression at + and - signs outside of custom die names. Every term starts with its sign
+func splitTerms(expression string) ([]string, error) {
+	if expression == "" {
+		return nil, errors.New("Empty roll")
+	}
+	terms := make([]string, 0)
+	current := ""
+	inName := false
+	for _, c := range expression {
+		switch {
+		case c == '[':
+			inName = true
+		case c == ']':
+			inName = false
+		case (c == '+' || c == '-') && !inName:
+			if current != "" {
+				terms = append(terms, current)
+			}
+			current = string(c)
+			continue
+		}
+		if current == "" {
+			current = "+"
+		}
+		current += string(c)
+	}
+	if len(current) <= 1 {
+		return nil, errors.New("Roll must not end with a sign")
+	}
+	return append(terms, current), nil
+}
+
+// parseRoll parses either the expression or the list of dice of a request
+func parseRoll(request RollRequest, settings RoomSettings) (Roll, error) {
+	if request.Expression != "" {
+		return parseExpression(request.Expression, settings)
+	}
+	if len(request.Dice) == 0 {
+		return Roll{}, errors.New("Empty roll")
+	}
+	roll := Roll{Groups: make([]DiceGroup, 0, len(request.Dice))}
+	for _, name := range request.Dice {
+		die, err := settings.die(name)
+		if err != nil {
+			return roll, err
+		}
+		roll.Groups = append(roll.Groups, DiceGroup{Count: 1, Die: die})
+	}
+	return roll, nil
+}
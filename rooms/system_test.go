@@ -0,0 +1,216 @@
+package rooms
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func testSettings() RoomSettings {
+	settings := DefaultSettings()
+	settings.AllowedDice = nil
+	settings.Dice = []Die{{Name: "boost", Faces: []Face{{Value: 0}, {Value: 1, Label: "Success"}}}}
+	return settings
+}
+
+func TestParseExpression(t *testing.T) {
+	d6, _ := builtinDie("d6")
+	d20, _ := builtinDie("d20")
+	d100, _ := builtinDie("d100")
+	d10, _ := builtinDie("d10")
+	boost := testSettings().Dice[0]
+	tests := []struct {
+		expression string
+		want       Roll
+		wantErr    bool
+	}{
+		{expression: "d6", want: Roll{Groups: []DiceGroup{{Count: 1, Die: d6}}}},
+		{expression: "4d6kh3+2", want: Roll{Groups: []DiceGroup{{Count: 4, Die: d6, KeepHighest: 3}}, Modifier: 2}},
+		{expression: "2d20kl1 - 1 + 3", want: Roll{Groups: []DiceGroup{{Count: 2, Die: d20, KeepLowest: 1}}, Modifier: 2}},
+		{expression: "d20-d6", want: Roll{Groups: []DiceGroup{{Count: 1, Die: d20}, {Count: 1, Die: d6, Negative: true}}}},
+		{expression: "d%", want: Roll{Groups: []DiceGroup{{Count: 1, Die: d100}}}},
+		{expression: "4dF-1", want: Roll{Groups: []DiceGroup{{Count: 4, Die: FudgeDie}}, Modifier: -1}},
+		{expression: "6d10>=8", want: Roll{Groups: []DiceGroup{{Count: 6, Die: d10, Target: 8}}}},
+		{expression: "2d[boost]", want: Roll{Groups: []DiceGroup{{Count: 2, Die: boost}}}},
+		{expression: "", wantErr: true},
+		{expression: "d6+", wantErr: true},
+		{expression: "0d6", wantErr: true},
+		{expression: "d7q", wantErr: true},
+		{expression: "d1", wantErr: true},
+		{expression: "2d[unknown]", wantErr: true},
+		{expression: "2d6kh3", wantErr: true},
+		{expression: "2d6kh0", wantErr: true},
+		{expression: "10001d6", wantErr: true},
+		{expression: "9000000000000000000d6+9000000000000000000d6", wantErr: true},
+		{expression: "99999999999999999999d6", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			roll, err := parseExpression(test.expression, testSettings())
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", roll)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(roll, test.want) {
+				t.Errorf("got %+v, want %+v", roll, test.want)
+			}
+		})
+	}
+}
+
+func TestParseExpressionAllowedDice(t *testing.T) {
+	settings := DefaultSettings()
+	if _, err := parseExpression("d6", settings); err != nil {
+		t.Error(err)
+	}
+	if _, err := parseExpression("d7", settings); err == nil {
+		t.Error("d7 is not allowed by default")
+	}
+}
+
+func TestDiceCount(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts []int
+		want   int
+	}{
+		{name: "empty", want: 0},
+		{name: "sum", counts: []int{4, 2}, want: 6},
+		{name: "saturates", counts: []int{maxInt, 1}, want: maxInt},
+		{name: "saturates twice", counts: []int{maxInt - 1, maxInt - 1}, want: maxInt},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var roll Roll
+			for _, count := range test.counts {
+				roll.Groups = append(roll.Groups, DiceGroup{Count: count})
+			}
+			if got := roll.DiceCount(); got != test.want {
+				t.Errorf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRollDiceKeeps(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, expression := range []string{"4d6kh3", "4d6kl1", "2d20kl1", "3d6"} {
+		t.Run(expression, func(t *testing.T) {
+			roll, err := parseExpression(expression, testSettings())
+			if err != nil {
+				t.Fatal(err)
+			}
+			group := roll.Groups[0]
+			want := group.KeepHighest + group.KeepLowest
+			if want == 0 {
+				want = group.Count
+			}
+			for i := 0; i < 100; i++ {
+				results := rollDice(roll, rng)
+				if len(results) != group.Count {
+					t.Fatalf("got %d results, want %d", len(results), group.Count)
+				}
+				kept, keptTotal := 0, 0
+				for _, result := range results {
+					if result.Result < 1 || result.Result > group.Die.Sides {
+						t.Fatalf("result %d out of range", result.Result)
+					}
+					if !result.Dropped {
+						kept++
+						keptTotal += result.Result
+					}
+				}
+				if kept != want {
+					t.Fatalf("kept %d dice, want %d", kept, want)
+				}
+				if total := roll.Total(results); total != keptTotal {
+					t.Fatalf("total %d, want %d", total, keptTotal)
+				}
+				for _, k := range results {
+					for _, d := range results {
+						if k.Dropped || !d.Dropped {
+							continue
+						}
+						if (group.KeepHighest > 0 && k.Result < d.Result) || (group.KeepLowest > 0 && k.Result > d.Result) {
+							t.Fatalf("kept %d but dropped %d in %+v", k.Result, d.Result, results)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestD20ParseRoll(t *testing.T) {
+	d20, _ := builtinDie("d20")
+	d6, _ := builtinDie("d6")
+	tests := []struct {
+		expression string
+		want       Roll
+		wantErr    bool
+	}{
+		{expression: "d20+5", want: Roll{Groups: []DiceGroup{{Count: 1, Die: d20}}, Modifier: 5}},
+		{expression: "adv", want: Roll{Groups: []DiceGroup{{Count: 2, Die: d20, KeepHighest: 1}}}},
+		{expression: "d20+5 adv", want: Roll{Groups: []DiceGroup{{Count: 2, Die: d20, KeepHighest: 1}}, Modifier: 5}},
+		{expression: "d20+5 advantage", want: Roll{Groups: []DiceGroup{{Count: 2, Die: d20, KeepHighest: 1}}, Modifier: 5}},
+		{expression: "d20 dis", want: Roll{Groups: []DiceGroup{{Count: 2, Die: d20, KeepLowest: 1}}}},
+		{expression: "d20 disadvantage", want: Roll{Groups: []DiceGroup{{Count: 2, Die: d20, KeepLowest: 1}}}},
+		{expression: "disadvantage", want: Roll{Groups: []DiceGroup{{Count: 2, Die: d20, KeepLowest: 1}}}},
+		{expression: "d6+d20 adv", want: Roll{Groups: []DiceGroup{{Count: 1, Die: d6}, {Count: 2, Die: d20, KeepHighest: 1}}}},
+		{expression: "d20 adv dis", wantErr: true},
+		{expression: "d20 disadvantage advantage", wantErr: true},
+		{expression: "2d20 adv", wantErr: true},
+		{expression: "d6 adv", wantErr: true},
+		{expression: "d20 sideways", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			roll, err := d20System{}.ParseRoll(RollRequest{Expression: test.expression}, testSettings())
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", roll)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(roll, test.want) {
+				t.Errorf("got %+v, want %+v", roll, test.want)
+			}
+		})
+	}
+}
+
+func TestGenericDescribe(t *testing.T) {
+	settings := testSettings()
+	settings.Dice = append(settings.Dice, Die{Name: "story", Faces: []Face{{Label: "Hit"}, {Label: "Miss"}}})
+	tests := []struct {
+		expression string
+		results    []RollResult
+		want       string
+	}{
+		{expression: "2d6+1", results: []RollResult{{Result: 3}, {Result: 5}}, want: "Total 9"},
+		// fudge dice have labels but still add up
+		{expression: "4dF+2", results: []RollResult{{Result: 1, Label: "+"}, {Result: 1, Label: "+"}, {Result: 0, Label: "0"}, {Result: -1, Label: "-"}}, want: "Total 3"},
+		{expression: "2dF", results: []RollResult{{Result: 1, Label: "+"}, {Result: 1, Label: "+"}}, want: "Total 2"},
+		{expression: "3d[story]", results: []RollResult{{Label: "Hit"}, {Label: "Miss"}, {Label: "Hit"}}, want: "2x Hit, 1x Miss"},
+		{expression: "2d[story]+1", results: []RollResult{{Label: "Hit"}, {Label: "Miss"}}, want: "Total 1"},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			roll, err := genericSystem{}.ParseRoll(RollRequest{Expression: test.expression}, settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := (genericSystem{}).Describe(roll, test.results); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
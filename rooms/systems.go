@@ -0,0 +1,246 @@
+package rooms
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+const (
+	// SystemGeneric adds up any polyhedral dice
+	SystemGeneric = "generic"
+	// SystemD20 is D&D style d20 with advantage and disadvantage
+	SystemD20 = "d20"
+	// SystemFate rolls 4dF and describes the result on the Fate ladder
+	SystemFate = "fate"
+	// SystemPool counts successes of a dice pool
+	SystemPool = "pool"
+)
+
+func init() {
+	RegisterSystem(genericSystem{})
+	RegisterSystem(d20System{})
+	RegisterSystem(fateSystem{})
+	RegisterSystem(poolSystem{})
+}
+
+type genericSystem struct{}
+
+func (genericSystem) Name() string {
+	return SystemGeneric
+}
+
+func (genericSystem) ParseRoll(request RollRequest, settings RoomSettings) (Roll, error) {
+	return parseRoll(request, settings)
+}
+
+func (genericSystem) Resolve(roll Roll, rng *rand.Rand) []RollResult {
+	return rollDice(roll, rng)
+}
+
+func (genericSystem) Describe(roll Roll, results []RollResult) string {
+	// dice showing only labels (like narrative dice) can't be added up
+	if !labelsOnly(roll) {
+		return fmt.Sprintf("Total %d", roll.Total(results))
+	}
+	labels := make([]string, 0)
+	counts := make(map[string]int)
+	for _, result := range results {
+		if result.Dropped {
+			continue
+		}
+		if counts[result.Label] == 0 {
+			labels = append(labels, result.Label)
+		}
+		counts[result.Label]++
+	}
+	description := make([]string, 0, len(labels))
+	for _, label := range labels {
+		description = append(description, fmt.Sprintf("%dx %s", counts[label], label))
+	}
+	return strings.Join(description, ", ")
+}
+
+// labelsOnly reports whether a roll only has dice whose faces are labels without a value. Dice like dF have labels
+// but still add up
+func labelsOnly(roll Roll) bool {
+	if len(roll.Groups) == 0 || roll.Modifier != 0 {
+		return false
+	}
+	for _, group := range roll.Groups {
+		if len(group.Die.Faces) == 0 {
+			return false
+		}
+		for _, face := range group.Die.Faces {
+			if face.Label == "" || face.Value != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+type d20System struct {
+	genericSystem
+}
+
+func (d20System) Name() string {
+	return SystemD20
+}
+
+// d20Suffixes are the trailing words of a d20 roll. disadvantage has to be checked before advantage which it ends with
+var d20Suffixes = []struct {
+	suffix       string
+	disadvantage bool
+}{
+	{"disadvantage", true},
+	{"dis", true},
+	{"advantage", false},
+	{"adv", false},
+}
+
+// ParseRoll understands a trailing "adv" or "dis" which rolls the first d20 twice
+func (d20System) ParseRoll(request RollRequest, settings RoomSettings) (Roll, error) {
+	expression := strings.TrimSpace(request.Expression)
+	advantage, disadvantage := false, false
+	for trimmed := true; trimmed; {
+		trimmed = false
+		for _, s := range d20Suffixes {
+			if strings.HasSuffix(expression, s.suffix) {
+				expression = strings.TrimSpace(strings.TrimSuffix(expression, s.suffix))
+				disadvantage = disadvantage || s.disadvantage
+				advantage = advantage || !s.disadvantage
+				trimmed = true
+				break
+			}
+		}
+	}
+	if advantage && disadvantage {
+		return Roll{}, errors.New("Can't roll with advantage and disadvantage at once")
+	}
+	if advantage || disadvantage {
+		if err := settings.allows(FeatureModes); err != nil {
+			return Roll{}, err
+		}
+	}
+	if request.Expression != "" && expression == "" {
+		expression = "d20"
+	}
+	request.Expression = expression
+	roll, err := parseRoll(request, settings)
+	if err != nil || (!advantage && !disadvantage) {
+		return roll, err
+	}
+	for i, group := range roll.Groups {
+		if group.Die.Name == "d20" && group.Count == 1 && group.KeepHighest == 0 && group.KeepLowest == 0 {
+			roll.Groups[i].Count = 2
+			if advantage {
+				roll.Groups[i].KeepHighest = 1
+			} else {
+				roll.Groups[i].KeepLowest = 1
+			}
+			return roll, nil
+		}
+	}
+	return roll, fmt.Errorf("Advantage and disadvantage need a single d20")
+}
+
+func (s d20System) Describe(roll Roll, results []RollResult) string {
+	description := s.genericSystem.Describe(roll, results)
+	for _, result := range results {
+		if result.Dice != "d20" || result.Dropped {
+			continue
+		}
+		switch result.Result {
+		case 20:
+			return description + " (natural 20)"
+		case 1:
+			return description + " (natural 1)"
+		}
+		break
+	}
+	return description
+}
+
+type fateSystem struct {
+	genericSystem
+}
+
+var fateLadder = []string{"Terrible", "Poor", "Mediocre", "Average", "Fair", "Good", "Great", "Superb", "Fantastic", "Epic", "Legendary"}
+
+func (fateSystem) Name() string {
+	return SystemFate
+}
+
+// ParseRoll rolls 4dF plus the given modifier (the skill) if no dice are given
+func (fateSystem) ParseRoll(request RollRequest, settings RoomSettings) (Roll, error) {
+	if len(request.Dice) == 0 {
+		expression := strings.TrimSpace(request.Expression)
+		if !strings.Contains(expression, "d") {
+			if expression != "" && expression[0] != '+' && expression[0] != '-' {
+				expression = "+" + expression
+			}
+			request.Expression = "4dF" + expression
+		}
+	}
+	return parseRoll(request, settings)
+}
+
+func (fateSystem) Describe(roll Roll, results []RollResult) string {
+	total := roll.Total(results)
+	// the ladder starts at Terrible (-2)
+	i := total + 2
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(fateLadder) {
+		i = len(fateLadder) - 1
+	}
+	return fmt.Sprintf("%s (%+d)", fateLadder[i], total)
+}
+
+type poolSystem struct {
+	genericSystem
+}
+
+func (poolSystem) Name() string {
+	return SystemPool
+}
+
+// ParseRoll gives every group without a target the default target of its die
+func (poolSystem) ParseRoll(request RollRequest, settings RoomSettings) (Roll, error) {
+	roll, err := parseRoll(request, settings)
+	for i, group := range roll.Groups {
+		if group.Target == 0 {
+			roll.Groups[i].Target = poolTarget(group.Die)
+		}
+	}
+	return roll, err
+}
+
+// Describe counts all kept dice meeting their target
+func (poolSystem) Describe(roll Roll, results []RollResult) string {
+	successes := roll.Total(results)
+	if successes == 1 {
+		return "1 success"
+	}
+	return fmt.Sprintf("%d successes", successes)
+}
+
+// poolTarget is the default target of a die: 8 on d10, 5 on d6 and the highest face otherwise
+func poolTarget(die Die) int {
+	switch die.Sides {
+	case 10:
+		return 8
+	case 6:
+		return 5
+	}
+	highest := die.Sides
+	for i, face := range die.Faces {
+		if i == 0 || face.Value > highest {
+			highest = face.Value
+		}
+	}
+	return highest
+}
@@ -0,0 +1,264 @@
+package rooms
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	maxTables            = 50
+	maxTableEntries      = 1000
+	maxTableResultLength = 256
+	// maxTableWeight and maxTableTotalWeight keep the weights from overflowing the die rolled on weighted tables
+	maxTableWeight      = 1000000
+	maxTableTotalWeight = 1000000000
+	// maxTableDepth stops tables referencing each other in a loop
+	maxTableDepth = 5
+)
+
+var (
+	// ErrNotOwner is returned when somebody else than the owner tries to manage a room
+	ErrNotOwner = errors.New("Only the owner may do this")
+	// ErrTableNotFound is returned when a room has no table with the given name
+	ErrTableNotFound = errors.New("Table not found")
+	// ErrTooManyTables is returned when a room already has the maximum number of tables
+	ErrTooManyTables = fmt.Errorf("A room can't have more than %d tables", maxTables)
+)
+
+// TableEntry is a possible result of a random table
+type TableEntry struct {
+	// Weight of the entry in weighted tables
+	Weight int `json:"weight,omitempty"`
+	// From and To is the range of the die in tables with a die
+	From   int    `json:"from,omitempty"`
+	To     int    `json:"to,omitempty"`
+	Result string `json:"result"`
+	// Table is rolled as well when this entry is selected
+	Table string `json:"table,omitempty"`
+}
+
+// Table is a random table. If it has a die the entries are selected by range. Otherwise they are weighted
+type Table struct {
+	Die     string       `json:"die,omitempty"`
+	Entries []TableEntry `json:"entries"`
+}
+
+// RollTableRequest asks the room to roll on one of its tables
+type RollTableRequest struct {
+	Table string `json:"table"`
+}
+
+// Validate checks a table
+func (t Table) Validate() error {
+	if len(t.Entries) == 0 || len(t.Entries) > maxTableEntries {
+		return fmt.Errorf("Tables must have between 1 and %d entries", maxTableEntries)
+	}
+	if t.Die != "" {
+		if _, err := builtinDie(t.Die); err != nil {
+			return err
+		}
+	}
+	totalWeight := 0
+	for _, entry := range t.Entries {
+		if len(entry.Result) > maxTableResultLength {
+			return fmt.Errorf("Results must not be longer than %d characters", maxTableResultLength)
+		}
+		if entry.Result == "" && entry.Table == "" {
+			return errors.New("Entries need a result or a table")
+		}
+		if t.Die == "" && (entry.Weight < 1 || entry.Weight > maxTableWeight) {
+			return fmt.Errorf("Entries of weighted tables need a weight between 1 and %d", maxTableWeight)
+		}
+		totalWeight += entry.Weight
+		if totalWeight > maxTableTotalWeight {
+			return fmt.Errorf("The weights of a table must not add up to more than %d", maxTableTotalWeight)
+		}
+		if t.Die != "" && entry.From > entry.To {
+			return fmt.Errorf("Invalid range %d-%d", entry.From, entry.To)
+		}
+	}
+	return nil
+}
+
+// roll selects an entry. It returns the die roll which selected it
+func (t Table) roll(rng *rand.Rand) (RollResult, *TableEntry) {
+	if t.Die != "" {
+		die, _ := builtinDie(t.Die)
+		result := die.roll(rng)
+		for i, entry := range t.Entries {
+			if result.Result >= entry.From && result.Result <= entry.To {
+				return result, &t.Entries[i]
+			}
+		}
+		return result, nil
+	}
+
+	total := 0
+	for _, entry := range t.Entries {
+		total += entry.Weight
+	}
+	result := Die{Name: fmt.Sprintf("d%d", total), Sides: total}.roll(rng)
+	sum := 0
+	for i, entry := range t.Entries {
+		sum += entry.Weight
+		if result.Result <= sum {
+			return result, &t.Entries[i]
+		}
+	}
+	return result, nil
+}
+
+func (r *roomState) isOwnerToken(token string) bool {
+	session, ok := r.sessions[token]
+	return ok && token != "" && session.ID == r.owner
+}
+
+func (r *roomState) tableNames() []string {
+	names := make([]string, 0, len(r.tables))
+	for name := range r.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *roomState) sendTables() {
+	names := r.tableNames()
+	for _, roller := range r.rollers {
+		r.sendEvent(roller, Event{Type: "tables", Payload: names})
+	}
+}
+
+// rollTable rolls on a table and all tables referenced by the selected entries
+func (r *roomState) rollTable(from Roller, request RollTableRequest) {
+	if _, ok := r.tables[request.Table]; !ok {
+		r.sendError(from, ErrTableNotFound.Error())
+		return
+	}
+	if err := r.mayRoll(from); err != nil {
+		r.sendError(from, err.Error())
+		return
+	}
+	results := make([]RollResult, 0)
+	descriptions := make([]string, 0)
+	name := request.Table
+	for depth := 0; name != "" && depth < maxTableDepth; depth++ {
+		table, ok := r.tables[name]
+		if !ok {
+			descriptions = append(descriptions, fmt.Sprintf("(table %s is missing)", name))
+			break
+		}
+		var result RollResult
+		var entry *TableEntry
+		hash := r.dice.logTable(table)
+		r.dice.use(func(rng *rand.Rand) *LogEntry {
+			result, entry = table.roll(rng)
+			return &LogEntry{Type: LogTable, RollerID: from.ID, TableName: name, TableHash: hash, Results: []RollResult{result}}
+		})
+		results = append(results, result)
+		if entry == nil {
+			descriptions = append(descriptions, "Nothing")
+			break
+		}
+		if entry.Result != "" {
+			descriptions = append(descriptions, entry.Result)
+		}
+		name = entry.Table
+	}
+
+	roll := RollResults{
+		RollerID:    from.ID,
+		Name:        from.Name,
+		Date:        time.Now(),
+		Results:     results,
+		Table:       request.Table,
+		Description: strings.Join(descriptions, ", "),
+		Seeded:      r.dice.seeded(),
+	}
+	r.addToHistory(&roll)
+	for _, roller := range r.rollers {
+		r.sendRoll(roller, roll)
+	}
+}
+
+// Tables lists the tables of a room. token has to be the resume token of the owner
+func (m *Manager) Tables(roomName string, token string) ([]string, error) {
+	var names []string
+	err := m.inRoom(roomName, func(r *roomState) {
+		if r.isOwnerToken(token) {
+			names = r.tableNames()
+		}
+	})
+	if err == nil && names == nil {
+		err = ErrNotOwner
+	}
+	return names, err
+}
+
+// Table returns a table of a room. token has to be the resume token of the owner
+func (m *Manager) Table(roomName string, token string, name string) (Table, error) {
+	var table Table
+	var tableErr error
+	err := m.inRoom(roomName, func(r *roomState) {
+		if !r.isOwnerToken(token) {
+			tableErr = ErrNotOwner
+			return
+		}
+		var ok bool
+		table, ok = r.tables[name]
+		if !ok {
+			tableErr = ErrTableNotFound
+		}
+	})
+	if err != nil {
+		return table, err
+	}
+	return table, tableErr
+}
+
+// SetTable creates or replaces a table of a room. token has to be the resume token of the owner
+func (m *Manager) SetTable(roomName string, token string, name string, table Table) error {
+	var tableErr error
+	err := m.inRoom(roomName, func(r *roomState) {
+		if !r.isOwnerToken(token) {
+			tableErr = ErrNotOwner
+			return
+		}
+		if _, ok := r.tables[name]; !ok && len(r.tables) >= maxTables {
+			tableErr = ErrTooManyTables
+			return
+		}
+		r.tables[name] = table
+		r.log.Infof("Table %s uploaded", name)
+		r.sendTables()
+	})
+	if err != nil {
+		return err
+	}
+	return tableErr
+}
+
+// DeleteTable removes a table of a room. token has to be the resume token of the owner
+func (m *Manager) DeleteTable(roomName string, token string, name string) error {
+	var tableErr error
+	err := m.inRoom(roomName, func(r *roomState) {
+		if !r.isOwnerToken(token) {
+			tableErr = ErrNotOwner
+			return
+		}
+		if _, ok := r.tables[name]; !ok {
+			tableErr = ErrTableNotFound
+			return
+		}
+		delete(r.tables, name)
+		r.sendTables()
+	})
+	if err != nil {
+		return err
+	}
+	return tableErr
+}
@@ -0,0 +1,92 @@
+package rooms
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestTableValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		table   Table
+		wantErr bool
+	}{
+		{name: "weighted", table: Table{Entries: []TableEntry{{Weight: 1, Result: "a"}, {Weight: 3, Result: "b"}}}},
+		{name: "die", table: Table{Die: "d6", Entries: []TableEntry{{From: 1, To: 3, Result: "a"}, {From: 4, To: 6, Table: "other"}}}},
+		{name: "empty", table: Table{}, wantErr: true},
+		{name: "unknown die", table: Table{Die: "d7q", Entries: []TableEntry{{From: 1, To: 3, Result: "a"}}}, wantErr: true},
+		{name: "no result", table: Table{Entries: []TableEntry{{Weight: 1}}}, wantErr: true},
+		{name: "no weight", table: Table{Entries: []TableEntry{{Result: "a"}}}, wantErr: true},
+		{name: "negative weight", table: Table{Entries: []TableEntry{{Weight: -1, Result: "a"}}}, wantErr: true},
+		{name: "weight too high", table: Table{Entries: []TableEntry{{Weight: maxTableWeight + 1, Result: "a"}}}, wantErr: true},
+		{name: "overflowing weights", table: Table{Entries: []TableEntry{
+			{Weight: int(^uint(0) >> 1), Result: "a"}, {Weight: int(^uint(0) >> 1), Result: "b"},
+		}}, wantErr: true},
+		{name: "inverted range", table: Table{Die: "d6", Entries: []TableEntry{{From: 4, To: 3, Result: "a"}}}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.table.Validate()
+			if test.wantErr && err == nil {
+				t.Error("expected an error")
+			}
+			if !test.wantErr && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	total := Table{}
+	for i := 0; i <= maxTableTotalWeight/maxTableWeight; i++ {
+		total.Entries = append(total.Entries, TableEntry{Weight: maxTableWeight, Result: "a"})
+	}
+	if err := total.Validate(); err == nil {
+		t.Error("expected the total weight to be limited")
+	}
+}
+
+func TestTableRoll(t *testing.T) {
+	tests := []struct {
+		name  string
+		table Table
+		// want maps the results of the die to the selected entry. -1 selects nothing
+		want map[int]int
+	}{
+		{
+			name:  "weighted",
+			table: Table{Entries: []TableEntry{{Weight: 1, Result: "a"}, {Weight: 3, Result: "b"}, {Weight: 2, Result: "c"}}},
+			want:  map[int]int{1: 0, 2: 1, 3: 1, 4: 1, 5: 2, 6: 2},
+		},
+		{
+			name:  "die",
+			table: Table{Die: "d6", Entries: []TableEntry{{From: 1, To: 2, Result: "a"}, {From: 3, To: 5, Result: "b"}}},
+			want:  map[int]int{1: 0, 2: 0, 3: 1, 4: 1, 5: 1, 6: -1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			seen := make(map[int]bool)
+			for i := 0; i < 1000; i++ {
+				result, entry := test.table.roll(rng)
+				want, ok := test.want[result.Result]
+				if !ok {
+					t.Fatalf("unexpected result %d", result.Result)
+				}
+				seen[result.Result] = true
+				if want < 0 {
+					if entry != nil {
+						t.Fatalf("%d selected %+v instead of nothing", result.Result, entry)
+					}
+					continue
+				}
+				if entry != &test.table.Entries[want] {
+					t.Fatalf("%d selected %+v instead of %+v", result.Result, entry, test.table.Entries[want])
+				}
+			}
+			if len(seen) != len(test.want) {
+				t.Errorf("only rolled %v", seen)
+			}
+		})
+	}
+}
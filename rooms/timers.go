@@ -0,0 +1,228 @@
+package rooms
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// TimerCountdown runs down from its duration and expires
+	TimerCountdown = "countdown"
+	// TimerStopwatch counts up until it is paused
+	TimerStopwatch = "stopwatch"
+
+	// TimerStart starts or resumes a timer. Unknown timers are created
+	TimerStart = "start"
+	// TimerPause stops a timer keeping the elapsed time
+	TimerPause = "pause"
+	// TimerReset stops a timer and sets it back to zero
+	TimerReset = "reset"
+	// TimerRemove deletes a timer
+	TimerRemove = "remove"
+
+	maxTimers          = 10
+	maxTimerNameLength = 32
+	maxTimerDuration   = 24 * time.Hour
+	// timerSyncInterval is how often running timers are broadcast so clocks don't drift apart
+	timerSyncInterval = 5 * time.Second
+)
+
+// TimerRequest controls a named timer of the room. Only the GM may control timers. Duration is in seconds and only
+// needed when starting a new countdown
+type TimerRequest struct {
+	Op       string `json:"op"`
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	Duration int    `json:"duration"`
+}
+
+// TimerInfo is the state of a timer at ServerTime. Elapsed and Duration are in milliseconds. Running timers have
+// advanced by the time passed since ServerTime
+type TimerInfo struct {
+	Name       string    `json:"name"`
+	Kind       string    `json:"kind"`
+	Duration   int64     `json:"duration,omitempty"`
+	Elapsed    int64     `json:"elapsed"`
+	Running    bool      `json:"running"`
+	Expired    bool      `json:"expired"`
+	ServerTime time.Time `json:"serverTime"`
+}
+
+// roomTimer is owned by the room goroutine. Timers don't survive a restart of the server
+type roomTimer struct {
+	name     string
+	kind     string
+	duration time.Duration
+	// elapsed is the time which passed before started
+	elapsed time.Duration
+	// started is set while the timer is running
+	started *time.Time
+	expired bool
+	// generation is unique within the room while the timer is running and 0 otherwise. An expiry which has already
+	// been scheduled does nothing once the timer has been stopped, even if it has been removed and created again
+	generation uint64
+	expiry     *time.Timer
+}
+
+func (t *roomTimer) info(now time.Time) TimerInfo {
+	elapsed := t.elapsed
+	if t.started != nil {
+		elapsed += now.Sub(*t.started)
+	}
+	if t.kind == TimerCountdown && elapsed > t.duration {
+		elapsed = t.duration
+	}
+	return TimerInfo{
+		Name:       t.name,
+		Kind:       t.kind,
+		Duration:   t.duration.Milliseconds(),
+		Elapsed:    elapsed.Milliseconds(),
+		Running:    t.started != nil,
+		Expired:    t.expired,
+		ServerTime: now,
+	}
+}
+
+// stop halts the timer keeping the elapsed time
+func (t *roomTimer) stop(now time.Time) {
+	if t.started != nil {
+		t.elapsed += now.Sub(*t.started)
+		t.started = nil
+	}
+	if t.expiry != nil {
+		t.expiry.Stop()
+		t.expiry = nil
+	}
+	t.generation = 0
+}
+
+func (r *roomState) timerInfos() []TimerInfo {
+	now := time.Now()
+	infos := make([]TimerInfo, 0, len(r.timers))
+	for _, timer := range r.timers {
+		infos = append(infos, timer.info(now))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+func (r *roomState) sendTimers() {
+	event := Event{Type: "timers", Payload: r.timerInfos()}
+	for _, roller := range r.rollers {
+		r.sendEvent(roller, event)
+	}
+}
+
+// scheduleTimerSync keeps broadcasting the timers as long as one of them is running
+func (r *roomState) scheduleTimerSync() {
+	if r.timerSync != nil {
+		return
+	}
+	r.timerSync = r.after(timerSyncInterval, func(r *roomState) {
+		r.timerSync = nil
+		for _, timer := range r.timers {
+			if timer.started != nil {
+				r.sendTimers()
+				r.scheduleTimerSync()
+				return
+			}
+		}
+	})
+}
+
+func (r *roomState) startTimer(from Roller, request TimerRequest) error {
+	timer, ok := r.timers[request.Name]
+	if !ok {
+		if len(request.Name) == 0 || len(request.Name) > maxTimerNameLength {
+			return fmt.Errorf("Timer names must have between 1 and %d characters", maxTimerNameLength)
+		}
+		if len(r.timers) >= maxTimers {
+			return fmt.Errorf("A room can't have more than %d timers", maxTimers)
+		}
+		timer = &roomTimer{name: request.Name, kind: request.Kind}
+		switch request.Kind {
+		case TimerCountdown:
+			// checked before multiplying which could overflow
+			if request.Duration <= 0 || request.Duration > int(maxTimerDuration/time.Second) {
+				return fmt.Errorf("Countdowns must run between 1 second and %v", maxTimerDuration)
+			}
+			timer.duration = time.Duration(request.Duration) * time.Second
+		case TimerStopwatch:
+		default:
+			return fmt.Errorf("Unknown timer kind %s", request.Kind)
+		}
+		r.timers[request.Name] = timer
+	}
+	if timer.started != nil {
+		return nil
+	}
+	if timer.kind == TimerCountdown && timer.elapsed >= timer.duration {
+		return fmt.Errorf("Countdown %s has expired. Reset it first", timer.name)
+	}
+
+	now := time.Now()
+	timer.started = &now
+	r.timerGeneration++
+	timer.generation = r.timerGeneration
+	if timer.kind == TimerCountdown {
+		name := timer.name
+		generation := timer.generation
+		timer.expiry = r.after(timer.duration-timer.elapsed, func(r *roomState) {
+			timer, ok := r.timers[name]
+			if !ok || timer.generation != generation {
+				return
+			}
+			timer.stop(time.Now())
+			timer.elapsed = timer.duration
+			timer.expired = true
+			r.log.Infof("Countdown %s expired", name)
+			info := timer.info(time.Now())
+			for _, roller := range r.rollers {
+				r.sendEvent(roller, Event{Type: "timerExpired", Payload: info})
+			}
+			r.sendTimers()
+		})
+	}
+	r.log.Infof("%s started timer %s", from.ID, timer.name)
+	r.scheduleTimerSync()
+	return nil
+}
+
+func (r *roomState) controlTimer(from Roller, request TimerRequest) {
+	if from.ID != r.owner {
+		r.sendError(from, "Only the GM may control timers")
+		return
+	}
+	if request.Op == TimerStart {
+		if err := r.startTimer(from, request); err != nil {
+			r.sendError(from, err.Error())
+			return
+		}
+		r.sendTimers()
+		return
+	}
+
+	timer, ok := r.timers[request.Name]
+	if !ok {
+		r.sendError(from, "Timer not found")
+		return
+	}
+	switch request.Op {
+	case TimerPause:
+		timer.stop(time.Now())
+	case TimerReset:
+		timer.stop(time.Now())
+		timer.elapsed = 0
+		timer.expired = false
+	case TimerRemove:
+		timer.stop(time.Now())
+		delete(r.timers, request.Name)
+	default:
+		r.sendError(from, fmt.Sprintf("Unknown operation %s", request.Op))
+		return
+	}
+	r.sendTimers()
+}
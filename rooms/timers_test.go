@@ -0,0 +1,106 @@
+package rooms
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// expectTimers skips everything until the timers match. They are synced periodically and after every change
+func expectTimers(t *testing.T, roller Roller, match func(timers []TimerInfo) bool) []TimerInfo {
+	t.Helper()
+	for {
+		if timers := expect(t, roller, "timers").Payload.([]TimerInfo); match(timers) {
+			return timers
+		}
+	}
+}
+
+func TestTimers(t *testing.T) {
+	m := newTestManager(t, testConfig(), nil)
+	name, err := m.CreateRoom("timers", DefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gm := join(t, m, name, JoinRequest{Name: "gm"})
+	bob := join(t, m, name, JoinRequest{Name: "bob"})
+	// the timers are sent when joining
+	expectTimers(t, gm, func(timers []TimerInfo) bool { return len(timers) == 0 })
+
+	bob.Requests <- &TimerRequest{Op: TimerStart, Name: "round", Kind: TimerStopwatch}
+	if message := expect(t, bob, "error").Payload; message != "Only the GM may control timers" {
+		t.Errorf("got error %v", message)
+	}
+	for _, request := range []TimerRequest{
+		{Op: TimerStart, Name: "round", Kind: "hourglass"},
+		{Op: TimerStart, Name: "round", Kind: TimerCountdown},
+		{Op: TimerStart, Name: "round", Kind: TimerCountdown, Duration: int(maxTimerDuration/time.Second) + 1},
+		// would overflow when multiplied with a second
+		{Op: TimerStart, Name: "round", Kind: TimerCountdown, Duration: math.MaxInt64/int(time.Second) + 1},
+		{Op: TimerPause, Name: "missing"},
+	} {
+		request := request
+		gm.Requests <- &request
+		expect(t, gm, "error")
+	}
+
+	start := time.Now()
+	gm.Requests <- &TimerRequest{Op: TimerStart, Name: "round", Kind: TimerCountdown, Duration: 1}
+	timers := expectTimers(t, bob, func(timers []TimerInfo) bool { return len(timers) == 1 })
+	if !timers[0].Running || timers[0].Duration != 1000 {
+		t.Errorf("got %+v", timers)
+	}
+	info := expect(t, bob, "timerExpired").Payload.(TimerInfo)
+	if time.Since(start) < time.Second || !info.Expired || info.Running || info.Elapsed != 1000 {
+		t.Errorf("got %+v after %v", info, time.Since(start))
+	}
+	gm.Requests <- &TimerRequest{Op: TimerStart, Name: "round"}
+	if message := expect(t, gm, "error").Payload; message != "Countdown round has expired. Reset it first" {
+		t.Errorf("got error %v", message)
+	}
+	gm.Requests <- &TimerRequest{Op: TimerReset, Name: "round"}
+	timers = expectTimers(t, bob, func(timers []TimerInfo) bool { return !timers[0].Expired })
+	if timers[0].Running || timers[0].Elapsed != 0 {
+		t.Errorf("got %+v", timers)
+	}
+
+	gm.Requests <- &TimerRequest{Op: TimerStart, Name: "watch", Kind: TimerStopwatch}
+	expectTimers(t, bob, func(timers []TimerInfo) bool { return len(timers) == 2 && timers[1].Running })
+	gm.Requests <- &TimerRequest{Op: TimerPause, Name: "watch"}
+	timers = expectTimers(t, bob, func(timers []TimerInfo) bool { return !timers[1].Running })
+	if timers[1].Name != "watch" || timers[1].Kind != TimerStopwatch {
+		t.Errorf("got %+v", timers)
+	}
+	gm.Requests <- &TimerRequest{Op: TimerRemove, Name: "watch"}
+	expectTimers(t, bob, func(timers []TimerInfo) bool { return len(timers) == 1 })
+}
+
+func TestTimerGenerations(t *testing.T) {
+	m := newTestManager(t, testConfig(), nil)
+	name, err := m.CreateRoom("timers", DefaultSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gm := join(t, m, name, JoinRequest{Name: "gm"})
+	go drain(gm)
+	request := TimerRequest{Op: TimerStart, Name: "round", Kind: TimerCountdown, Duration: 60}
+	err = m.inRoom(name, func(r *roomState) {
+		generations := make(map[uint64]bool)
+		// removing and creating a timer again must not revive an expiry which has already been scheduled
+		for i := 0; i < 3; i++ {
+			if err := r.startTimer(gm, request); err != nil {
+				t.Fatal(err)
+			}
+			generation := r.timers["round"].generation
+			if generation == 0 || generations[generation] {
+				t.Errorf("got generation %d again", generation)
+			}
+			generations[generation] = true
+			r.timers["round"].stop(time.Now())
+			delete(r.timers, "round")
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,229 @@
+package rooms
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// CounterSet sets a counter to a value. The counter is created if it doesn't exist
+	CounterSet = "set"
+	// CounterAdd adds to a counter
+	CounterAdd = "add"
+	// CounterSubtract subtracts from a counter like damage from hit points
+	CounterSubtract = "subtract"
+	// CounterDelete removes a counter
+	CounterDelete = "delete"
+
+	maxCounters          = 20
+	maxTags              = 20
+	maxTrackerNameLength = 32
+)
+
+// Counter is a named number like hit points or spell slots. The value stays between Min and Max if they are set
+type Counter struct {
+	Name  string `json:"name"`
+	Value int    `json:"value"`
+	Min   *int   `json:"min,omitempty"`
+	Max   *int   `json:"max,omitempty"`
+}
+
+// Tracker holds the counters and tags (like conditions) of a roller or an NPC
+type Tracker struct {
+	// Owner is the ID of the roller or NPC. Only the owner and the GM may change the tracker
+	Owner    string    `json:"owner"`
+	Counters []Counter `json:"counters"`
+	Tags     []string  `json:"tags"`
+}
+
+// UpdateCounterRequest changes a counter of a tracker. If Roll is the ID of a roll its total is used instead of Value
+type UpdateCounterRequest struct {
+	Owner string `json:"owner"`
+	Name  string `json:"name"`
+	Op    string `json:"op"`
+	Value int    `json:"value"`
+	Roll  string `json:"roll"`
+	// Min and Max replace the limits of the counter if set
+	Min *int `json:"min"`
+	Max *int `json:"max"`
+}
+
+// TagRequest adds a tag to a tracker or removes it
+type TagRequest struct {
+	Owner  string `json:"owner"`
+	Tag    string `json:"tag"`
+	Remove bool   `json:"remove"`
+}
+
+// clamp keeps the value of a counter within its limits
+func (c *Counter) clamp() {
+	if c.Min != nil && c.Value < *c.Min {
+		c.Value = *c.Min
+	}
+	if c.Max != nil && c.Value > *c.Max {
+		c.Value = *c.Max
+	}
+}
+
+// tracker returns the tracker of a roller or NPC which may be changed by from. It is created if necessary
+func (r *roomState) tracker(from Roller, owner string) (*Tracker, error) {
+	if owner == "" {
+		owner = from.ID
+	}
+	if owner != from.ID && from.ID != r.owner {
+		return nil, errors.New("Only the GM may change the trackers of others")
+	}
+	for i := range r.trackers {
+		if r.trackers[i].Owner == owner {
+			return &r.trackers[i], nil
+		}
+	}
+	known := r.findNPC(owner) >= 0
+	for _, member := range r.members {
+		if member.ID == owner {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return nil, errors.New("Unknown roller")
+	}
+	r.trackers = append(r.trackers, Tracker{Owner: owner, Counters: make([]Counter, 0), Tags: make([]string, 0)})
+	return &r.trackers[len(r.trackers)-1], nil
+}
+
+// rollTotal looks up the total of a roll in the history
+func (r *roomState) rollTotal(id string) (int, error) {
+	for i := len(r.history) - 1; i >= 0; i-- {
+		roll := r.history[i]
+		if roll.ID != id {
+			continue
+		}
+		if roll.Secret != nil && roll.Secret.Nonce == "" {
+			return 0, errors.New("Secret rolls can't be used before they are revealed")
+		}
+		return roll.Total, nil
+	}
+	return 0, errors.New("Roll not found")
+}
+
+// trackerInfos copies the trackers. They are changed in place while the events may not have been sent yet
+func (r *roomState) trackerInfos() []Tracker {
+	trackers := make([]Tracker, 0, len(r.trackers))
+	for _, tracker := range r.trackers {
+		tracker.Counters = append(make([]Counter, 0, len(tracker.Counters)), tracker.Counters...)
+		tracker.Tags = append(make([]string, 0, len(tracker.Tags)), tracker.Tags...)
+		trackers = append(trackers, tracker)
+	}
+	return trackers
+}
+
+func (r *roomState) sendTrackers() {
+	trackers := r.trackerInfos()
+	for _, roller := range r.rollers {
+		r.sendEvent(roller, Event{Type: "trackers", Payload: trackers})
+	}
+}
+
+func (r *roomState) updateCounter(from Roller, request UpdateCounterRequest) {
+	if request.Name == "" || len(request.Name) > maxTrackerNameLength {
+		r.sendError(from, fmt.Sprintf("Counter names must have between 1 and %d characters", maxTrackerNameLength))
+		return
+	}
+	tracker, err := r.tracker(from, request.Owner)
+	if err != nil {
+		r.sendError(from, err.Error())
+		return
+	}
+	value := request.Value
+	if request.Roll != "" {
+		if value, err = r.rollTotal(request.Roll); err != nil {
+			r.sendError(from, err.Error())
+			return
+		}
+	}
+
+	i := 0
+	for ; i < len(tracker.Counters); i++ {
+		if tracker.Counters[i].Name == request.Name {
+			break
+		}
+	}
+	if i == len(tracker.Counters) {
+		if request.Op != CounterSet {
+			r.sendError(from, "Counter not found")
+			return
+		}
+		if len(tracker.Counters) >= maxCounters {
+			r.sendError(from, fmt.Sprintf("A tracker can't have more than %d counters", maxCounters))
+			return
+		}
+		tracker.Counters = append(tracker.Counters, Counter{Name: request.Name})
+	}
+
+	counter := &tracker.Counters[i]
+	switch request.Op {
+	case CounterSet:
+		counter.Value = value
+	case CounterAdd:
+		counter.Value += value
+	case CounterSubtract:
+		counter.Value -= value
+	case CounterDelete:
+		tracker.Counters = append(tracker.Counters[:i], tracker.Counters[i+1:]...)
+		r.sendTrackers()
+		return
+	default:
+		r.sendError(from, fmt.Sprintf("Unknown operation %s", request.Op))
+		return
+	}
+	if request.Min != nil {
+		counter.Min = request.Min
+	}
+	if request.Max != nil {
+		counter.Max = request.Max
+	}
+	counter.clamp()
+	r.sendTrackers()
+}
+
+func (r *roomState) tag(from Roller, request TagRequest) {
+	if request.Tag == "" || len(request.Tag) > maxTrackerNameLength {
+		r.sendError(from, fmt.Sprintf("Tags must have between 1 and %d characters", maxTrackerNameLength))
+		return
+	}
+	tracker, err := r.tracker(from, request.Owner)
+	if err != nil {
+		r.sendError(from, err.Error())
+		return
+	}
+	for i, tag := range tracker.Tags {
+		if tag == request.Tag {
+			if request.Remove {
+				tracker.Tags = append(tracker.Tags[:i], tracker.Tags[i+1:]...)
+				r.sendTrackers()
+			}
+			return
+		}
+	}
+	if request.Remove {
+		return
+	}
+	if len(tracker.Tags) >= maxTags {
+		r.sendError(from, fmt.Sprintf("A tracker can't have more than %d tags", maxTags))
+		return
+	}
+	tracker.Tags = append(tracker.Tags, request.Tag)
+	r.sendTrackers()
+}
+
+// removeTracker drops the tracker of a removed NPC
+func (r *roomState) removeTracker(owner string) {
+	for i, tracker := range r.trackers {
+		if tracker.Owner == owner {
+			r.trackers = append(r.trackers[:i], r.trackers[i+1:]...)
+			r.sendTrackers()
+			return
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package rooms
+
+import (
+	"reflect"
+	"testing"
+)
+
+// expectTrackers skips everything until the next trackers event
+func expectTrackers(t *testing.T, roller Roller) []Tracker {
+	t.Helper()
+	return expect(t, roller, "trackers").Payload.([]Tracker)
+}
+
+func TestTrackers(t *testing.T) {
+	m := newTestManager(t, testConfig(), nil)
+	settings := testSettings()
+	settings.Dice = append(settings.Dice, Die{Name: "one", Faces: []Face{{Value: 1}}})
+	name, err := m.CreateRoom("trackers", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gm := join(t, m, name, JoinRequest{Name: "gm"})
+	bob := join(t, m, name, JoinRequest{Name: "bob"})
+	// the trackers are sent when joining
+	expectTrackers(t, bob)
+
+	max := 12
+	bob.Requests <- &UpdateCounterRequest{Name: "hp", Op: CounterSet, Value: 10, Max: &max}
+	first := expectTrackers(t, bob)
+	bob.Requests <- &UpdateCounterRequest{Name: "hp", Op: CounterAdd, Value: 5}
+	if trackers := expectTrackers(t, bob); trackers[0].Counters[0].Value != max {
+		t.Errorf("the counter hasn't been clamped: %+v", trackers)
+	}
+	bob.RollRequestChan <- RollRequest{Expression: "3d[one]"}
+	roll := expectRoll(t, bob)
+	bob.Requests <- &UpdateCounterRequest{Name: "hp", Op: CounterSubtract, Roll: roll.ID}
+	bob.Requests <- &TagRequest{Tag: "prone"}
+	expectTrackers(t, bob)
+	want := []Tracker{{Owner: bob.ID, Counters: []Counter{{Name: "hp", Value: 9, Max: &max}}, Tags: []string{"prone"}}}
+	if trackers := expectTrackers(t, bob); !reflect.DeepEqual(trackers, want) {
+		t.Errorf("got %+v, want %+v", trackers, want)
+	}
+	// events which have been sent don't change
+	if first[0].Counters[0].Value != 10 || len(first[0].Tags) != 0 {
+		t.Errorf("the first event changed to %+v", first)
+	}
+
+	bob.Requests <- &UpdateCounterRequest{Owner: gm.ID, Name: "hp", Op: CounterSet}
+	if message := expect(t, bob, "error").Payload; message != "Only the GM may change the trackers of others" {
+		t.Errorf("got error %v", message)
+	}
+	bob.Requests <- &UpdateCounterRequest{Name: "mana", Op: CounterAdd, Value: 1}
+	if message := expect(t, bob, "error").Payload; message != "Counter not found" {
+		t.Errorf("got error %v", message)
+	}
+	gm.Requests <- &UpdateCounterRequest{Owner: "nobody", Name: "hp", Op: CounterSet}
+	if message := expect(t, gm, "error").Payload; message != "Unknown roller" {
+		t.Errorf("got error %v", message)
+	}
+
+	gm.Requests <- &TagRequest{Owner: bob.ID, Tag: "prone", Remove: true}
+	if trackers := expectTrackers(t, bob); len(trackers[0].Tags) != 0 {
+		t.Errorf("the tag hasn't been removed: %+v", trackers)
+	}
+	gm.Requests <- &UpdateCounterRequest{Owner: bob.ID, Name: "hp", Op: CounterDelete}
+	if trackers := expectTrackers(t, bob); len(trackers[0].Counters) != 0 {
+		t.Errorf("the counter hasn't been deleted: %+v", trackers)
+	}
+}
@@ -0,0 +1,156 @@
+package rooms
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// TurnSet replaces the turn order. The first one in the order gets the turn
+	TurnSet = "set"
+	// TurnNext passes the turn to the next one in the order
+	TurnNext = "next"
+	// TurnSkip passes over the next one in the order so they miss their turn
+	TurnSkip = "skip"
+	// TurnInsert adds somebody to the order. Without a position they are next
+	TurnInsert = "insert"
+
+	maxTurns = 100
+)
+
+// TurnRequest changes the turn order. Only the GM may change it, except that whoever has the turn may pass it on
+type TurnRequest struct {
+	Op string `json:"op"`
+	// Order are the IDs of rollers and NPCs for TurnSet
+	Order []string `json:"order"`
+	// ID is the roller or NPC to insert
+	ID string `json:"id"`
+	// Position in the order for TurnInsert. Inserts after the current turn if nil
+	Position *int `json:"position"`
+}
+
+// turnHolder returns the ID of whoever has the turn or an empty string if there is no turn order
+func (r *roomState) turnHolder() string {
+	if len(r.turns) == 0 {
+		return ""
+	}
+	return r.turns[r.turn]
+}
+
+// mayRoll checks whether a roller may roll publicly. If the room is restricted to turns only the active player and
+// the GM may roll
+func (r *roomState) mayRoll(from Roller) error {
+	if !r.settings.get().TurnsOnly || from.ID == r.owner || len(r.turns) == 0 {
+		return nil
+	}
+	if r.turnHolder() != from.ID {
+		return errors.New("It's not your turn")
+	}
+	return nil
+}
+
+// validTurnID checks whether somebody may be part of the turn order
+func (r *roomState) validTurnID(id string) bool {
+	if r.findNPC(id) >= 0 {
+		return true
+	}
+	for _, member := range r.members {
+		if member.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *roomState) changeTurns(from Roller, request TurnRequest) {
+	if from.ID != r.owner && !(request.Op == TurnNext && from.ID == r.turnHolder()) {
+		r.sendError(from, "Only the GM may change the turn order")
+		return
+	}
+	switch request.Op {
+	case TurnSet:
+		if len(request.Order) > maxTurns {
+			r.sendError(from, fmt.Sprintf("The turn order can't have more than %d entries", maxTurns))
+			return
+		}
+		seen := make(map[string]bool, len(request.Order))
+		for _, id := range request.Order {
+			if !r.validTurnID(id) {
+				r.sendError(from, "Unknown roller")
+				return
+			}
+			if seen[id] {
+				r.sendError(from, "Everybody may only appear once in the turn order")
+				return
+			}
+			seen[id] = true
+		}
+		r.turns = append(make([]string, 0, len(request.Order)), request.Order...)
+		r.turn = 0
+	case TurnNext, TurnSkip:
+		if len(r.turns) == 0 {
+			r.sendError(from, "There is no turn order")
+			return
+		}
+		steps := 1
+		if request.Op == TurnSkip {
+			steps = 2
+		}
+		r.turn = (r.turn + steps) % len(r.turns)
+	case TurnInsert:
+		if !r.validTurnID(request.ID) {
+			r.sendError(from, "Unknown roller")
+			return
+		}
+		for _, id := range r.turns {
+			if id == request.ID {
+				r.sendError(from, "Everybody may only appear once in the turn order")
+				return
+			}
+		}
+		if len(r.turns) >= maxTurns {
+			r.sendError(from, fmt.Sprintf("The turn order can't have more than %d entries", maxTurns))
+			return
+		}
+		position := r.turn + 1
+		if len(r.turns) == 0 {
+			position = 0
+		}
+		if request.Position != nil {
+			position = *request.Position
+		}
+		if position < 0 || position > len(r.turns) {
+			r.sendError(from, "Invalid position")
+			return
+		}
+		r.turns = append(r.turns, "")
+		copy(r.turns[position+1:], r.turns[position:])
+		r.turns[position] = request.ID
+		// the turn stays with whoever has it
+		if len(r.turns) > 1 && position <= r.turn {
+			r.turn++
+		}
+	default:
+		r.sendError(from, fmt.Sprintf("Unknown operation %s", request.Op))
+		return
+	}
+	r.sendUserUpdates()
+}
+
+// removeTurn drops a removed NPC from the turn order
+func (r *roomState) removeTurn(id string) {
+	for i, turn := range r.turns {
+		if turn != id {
+			continue
+		}
+		r.turns = append(r.turns[:i], r.turns[i+1:]...)
+		if i < r.turn {
+			r.turn--
+		}
+		// the turn passes on to whoever was next
+		if r.turn >= len(r.turns) {
+			r.turn = 0
+		}
+		return
+	}
+}
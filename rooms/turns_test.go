@@ -0,0 +1,89 @@
+package rooms
+
+import (
+	"testing"
+	"time"
+)
+
+// expectTurn reads everything a roller gets until the turn has been passed to id
+func expectTurn(t *testing.T, roller Roller, id string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case update := <-roller.UsersUpdate:
+			if update.Turn == id {
+				return
+			}
+		case <-roller.Events:
+		case <-roller.RollResultsChan:
+		case <-roller.Done:
+			t.Fatalf("%s has been disconnected while waiting for the turn of %s", roller.Name, id)
+		case <-timeout:
+			t.Fatalf("the turn hasn't been passed to %s", id)
+		}
+	}
+}
+
+// expectOwnRoll skips the rolls of the others
+func expectOwnRoll(t *testing.T, roller Roller) RollResults {
+	t.Helper()
+	for {
+		if roll := expectRoll(t, roller); roll.RollerID == roller.ID {
+			return roll
+		}
+	}
+}
+
+func TestTurnsOnly(t *testing.T) {
+	m := newTestManager(t, testConfig(), nil)
+	settings := DefaultSettings()
+	settings.TurnsOnly = true
+	name, err := m.CreateRoom("turns", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gm := join(t, m, name, JoinRequest{Name: "gm"})
+	bob := join(t, m, name, JoinRequest{Name: "bob"})
+	carol := join(t, m, name, JoinRequest{Name: "carol"})
+
+	bob.Requests <- &TurnRequest{Op: TurnSet, Order: []string{bob.ID, carol.ID}}
+	if message := expect(t, bob, "error").Payload; message != "Only the GM may change the turn order" {
+		t.Errorf("got error %v", message)
+	}
+	gm.Requests <- &TurnRequest{Op: TurnSet, Order: []string{bob.ID, carol.ID}}
+	expectTurn(t, carol, bob.ID)
+
+	carol.RollRequestChan <- RollRequest{Expression: "d6"}
+	if message := expect(t, carol, "error").Payload; message != "It's not your turn" {
+		t.Errorf("got error %v", message)
+	}
+	carol.Requests <- &ChallengeRequest{Opponent: bob.ID, Expression: "d20"}
+	if message := expect(t, carol, "error").Payload; message != "It's not your turn" {
+		t.Errorf("got error %v", message)
+	}
+	bob.RollRequestChan <- RollRequest{Expression: "d6"}
+	expectOwnRoll(t, bob)
+	gm.RollRequestChan <- RollRequest{Expression: "d6"}
+	expectOwnRoll(t, gm)
+
+	// the challenged opponent may roll out of turn but the challenger has to wait for the next one
+	bob.Requests <- &ChallengeRequest{Opponent: carol.ID, Expression: "d20"}
+	c := expect(t, carol, "contest").Payload.(ContestInfo)
+	carol.Requests <- &ContestRollRequest{ID: c.ID}
+	expectOwnRoll(t, carol)
+	bob.Requests <- &TurnRequest{Op: TurnNext}
+	expectTurn(t, bob, carol.ID)
+	bob.Requests <- &ContestRollRequest{ID: c.ID}
+	if message := expect(t, bob, "error").Payload; message != "It's not your turn" {
+		t.Errorf("got error %v", message)
+	}
+
+	// everybody answers a roll call
+	gm.Requests <- &RollCallRequest{Expression: "d20"}
+	call := expect(t, bob, "rollcall").Payload.(RollCallInfo)
+	bob.Requests <- &AnswerRollCallRequest{ID: call.ID}
+	if roll := expectOwnRoll(t, bob); roll.RollCall != call.ID {
+		t.Errorf("got roll %+v", roll)
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/m0ppers/wuerfler/rooms"
+)
+
+func (s *Server) mountAdminRoutes(r chi.Router) {
+	// no token no admin
+	if s.conf.AdminToken == "" {
+		return
+	}
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(s.requireAdminToken)
+		r.Get("/rooms", s.adminListRooms)
+		r.Get("/rooms/{roomName}", s.adminInspectRoom)
+		r.Delete("/rooms/{roomName}", s.adminCloseRoom)
+		r.Delete("/rooms/{roomName}/rollers/{rollerID}", s.adminKickRoller)
+		r.Post("/notices", s.adminBroadcast)
+	})
+}
+
+func (s *Server) requireAdminToken(next http.Handler) http.Handler {
+	expected := []byte("Bearer " + s.conf.AdminToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := []byte(strings.TrimSpace(r.Header.Get("Authorization")))
+		if subtle.ConstantTimeCompare(authorization, expected) != 1 {
+			w.Header().Add("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(401), 401)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) writeRoomError(w http.ResponseWriter, err error) {
+	switch err {
+	case rooms.ErrRoomNotFound, rooms.ErrRollerNotFound, rooms.ErrTableNotFound, rooms.ErrNotSeeded, rooms.ErrMacroNotFound:
+		http.Error(w, http.StatusText(404), 404)
+	case rooms.ErrNotOwner:
+		http.Error(w, http.StatusText(403), 403)
+	case rooms.ErrTooManyTables, rooms.ErrTooManyMacros:
+		http.Error(w, err.Error(), 409)
+	default:
+		http.Error(w, http.StatusText(500), 500)
+		s.log.Errorf("Room error: %v", err)
+	}
+}
+
+func (s *Server) adminListRooms(w http.ResponseWriter, req *http.Request) {
+	s.writeJSON(w, 200, s.roomManager.Rooms())
+}
+
+func (s *Server) adminInspectRoom(w http.ResponseWriter, req *http.Request) {
+	details, err := s.roomManager.Inspect(chi.URLParam(req, "roomName"))
+	if err != nil {
+		s.writeRoomError(w, err)
+		return
+	}
+	s.writeJSON(w, 200, &details)
+}
+
+func (s *Server) adminCloseRoom(w http.ResponseWriter, req *http.Request) {
+	err := s.roomManager.CloseRoom(chi.URLParam(req, "roomName"))
+	if err != nil {
+		s.writeRoomError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (s *Server) adminKickRoller(w http.ResponseWriter, req *http.Request) {
+	err := s.roomManager.Kick(chi.URLParam(req, "roomName"), chi.URLParam(req, "rollerID"), req.URL.Query().Get("reason"))
+	if err != nil {
+		s.writeRoomError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (s *Server) adminBroadcast(w http.ResponseWriter, req *http.Request) {
+	var message string
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&message)
+	if err != nil || message == "" {
+		http.Error(w, http.StatusText(400), 400)
+		return
+	}
+	s.roomManager.Broadcast(message)
+	w.WriteHeader(204)
+}
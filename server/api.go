@@ -2,31 +2,96 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/go-chi/chi"
+	"github.com/m0ppers/wuerfler/rooms"
 )
 
 func (s *Server) mountRestRoutes(r chi.Router) {
-	r.Post("/api/rooms", s.createRoom)
+	r.With(s.limitByIP(s.roomCreateLimiters, "createRoom")).Post("/api/rooms", s.createRoom)
+	r.Get("/api/rooms/{roomName}/archive", s.getArchive)
+	r.Get("/api/rooms/{roomName}/log", s.getRollLog)
+	r.With(s.limitByIP(s.probabilityLimiters, "probability")).Post("/api/probability", s.probability)
+	s.mountTableRoutes(r)
+	s.mountMacroRoutes(r)
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	json, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		s.log.Errorf("Error encoding json: %v", err)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(json)
+}
+
+func (s *Server) getArchive(w http.ResponseWriter, req *http.Request) {
+	archive, ok := s.roomManager.Archived(chi.URLParam(req, "roomName"))
+	if !ok {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+	s.writeJSON(w, 200, &archive)
+}
+
+// getRollLog returns the seed and roll log of a seeded room to the owner. It is the input of wuerfler-replay
+func (s *Server) getRollLog(w http.ResponseWriter, req *http.Request) {
+	log, err := s.roomManager.RollLog(chi.URLParam(req, "roomName"), ownerToken(req))
+	if err != nil {
+		s.writeRoomError(w, err)
+		return
+	}
+	s.writeJSON(w, 200, &log)
+}
+
+// CreateRoomPayload is the body of a create room request. Older clients just send the name as string
+type CreateRoomPayload struct {
+	Name     string             `json:"name"`
+	Settings rooms.RoomSettings `json:"settings"`
 }
 
 func (s *Server) createRoom(w http.ResponseWriter, req *http.Request) {
-	var roomName string
-	decoder := json.NewDecoder(req.Body)
-	err := decoder.Decode(&roomName)
+	var body json.RawMessage
+	decoder := json.NewDecoder(http.MaxBytesReader(w, req.Body, s.conf.MaxMessageSize))
+	err := decoder.Decode(&body)
 	if err != nil {
 		http.Error(w, http.StatusText(400), 400)
 		return
 	}
 
-	if len(roomName) > 1024 {
+	payload := CreateRoomPayload{
+		Settings: rooms.DefaultSettings(),
+	}
+	err = json.Unmarshal(body, &payload.Name)
+	if err != nil {
+		err = json.Unmarshal(body, &payload)
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(400), 400)
+		return
+	}
+	if err := payload.Settings.Validate(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	roomName := payload.Name
+
+	if len(roomName) > s.conf.MaxRoomNameLength {
 		// was erlaube?
 		http.Error(w, http.StatusText(400), 400)
 		return
 	}
 
-	roomName, err = s.roomManager.CreateRoom(roomName)
+	roomName, err = s.roomManager.CreateRoom(roomName, payload.Settings)
+	if err == rooms.ErrTooManyRooms {
+		http.Error(w, http.StatusText(503), 503)
+		return
+	}
 	if err != nil {
 		http.Error(w, http.StatusText(500), 500)
 		s.log.Errorf("Couldn't create room: %v", err)
@@ -43,3 +108,30 @@ func (s *Server) createRoom(w http.ResponseWriter, req *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 	w.Write(json)
 }
+
+// ProbabilityPayload asks for the odds of an expression in a game system. The system defaults to generic
+type ProbabilityPayload struct {
+	rooms.ProbabilityRequest
+	System string `json:"system"`
+}
+
+func (s *Server) probability(w http.ResponseWriter, req *http.Request) {
+	payload := ProbabilityPayload{System: rooms.SystemGeneric}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, req.Body, s.conf.MaxMessageSize))
+	err := decoder.Decode(&payload)
+	if err != nil {
+		http.Error(w, http.StatusText(400), 400)
+		return
+	}
+	roll, err := rooms.ParseRoll(payload.System, payload.Expression)
+	if err == nil && roll.DiceCount() > s.conf.MaxDicePerRoll {
+		err = errors.New("Too many dices")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	distribution := rooms.Probability(roll, payload.Target)
+	distribution.Expression = payload.Expression
+	s.writeJSON(w, 200, &distribution)
+}
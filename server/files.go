@@ -18,7 +18,8 @@ func (s *Server) mountFileRoutes(r chi.Router) {
 	}
 	r.Get("/rooms/{roomName}", func(w http.ResponseWriter, r *http.Request) {
 		roomName := chi.URLParam(r, "roomName")
-		if s.roomManager.Exists(roomName) {
+		_, archived := s.roomManager.Archived(roomName)
+		if s.roomManager.Exists(roomName) || archived {
 			http.ServeFile(w, r, filepath.Join(frontendDir, "index.html"))
 		} else {
 			http.Error(w, http.StatusText(404), 404)
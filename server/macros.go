@@ -0,0 +1,56 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/m0ppers/wuerfler/rooms"
+)
+
+func (s *Server) mountMacroRoutes(r chi.Router) {
+	r.Route("/api/rooms/{roomName}/macros", func(r chi.Router) {
+		r.Get("/", s.listMacros)
+		r.Put("/{macroName}", s.putMacro)
+		r.Delete("/{macroName}", s.deleteMacro)
+	})
+}
+
+func (s *Server) listMacros(w http.ResponseWriter, req *http.Request) {
+	macros, err := s.roomManager.Macros(chi.URLParam(req, "roomName"), ownerToken(req))
+	if err != nil {
+		s.writeRoomError(w, err)
+		return
+	}
+	s.writeJSON(w, 200, macros)
+}
+
+// putMacro uploads the Starlark source of a macro as plain text
+func (s *Server) putMacro(w http.ResponseWriter, req *http.Request) {
+	source, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, rooms.MaxMacroSize))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	name := chi.URLParam(req, "macroName")
+	if err := rooms.ValidateMacro(name, string(source)); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	err = s.roomManager.SetMacro(chi.URLParam(req, "roomName"), ownerToken(req), name, string(source))
+	if err != nil {
+		s.writeRoomError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (s *Server) deleteMacro(w http.ResponseWriter, req *http.Request) {
+	err := s.roomManager.DeleteMacro(chi.URLParam(req, "roomName"), ownerToken(req), chi.URLParam(req, "macroName"))
+	if err != nil {
+		s.writeRoomError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
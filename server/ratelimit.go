@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+var (
+	// RateLimitedCounter counts all requests and messages rejected because of rate limiting
+	RateLimitedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wuerfler_rate_limited_total",
+		Help: "Requests and messages rejected by rate limiting",
+	}, []string{"action"})
+)
+
+const (
+	// limiters of IPs not seen for this long will be forgotten
+	ipLimiterExpiry = 10 * time.Minute
+)
+
+// RateLimited is sent to clients whenever they exceeded one of their rate limits
+type RateLimited struct {
+	Action string `json:"action"`
+	// RetryAfter is the number of seconds the client should wait before trying again
+	RetryAfter float64 `json:"retryAfter"`
+}
+
+// newLimiter creates a token bucket. A rate of 0 means unlimited
+func newLimiter(r float64, burst int) *rate.Limiter {
+	if r <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(r), burst)
+}
+
+// allow takes a token from the bucket. If there is none it returns how long to wait for the next one
+func allow(l *rate.Limiter) (bool, time.Duration) {
+	reservation := l.Reserve()
+	if !reservation.OK() {
+		return false, time.Second
+	}
+	delay := reservation.Delay()
+	if delay == 0 {
+		return true, 0
+	}
+	reservation.Cancel()
+	return false, delay
+}
+
+func newRateLimited(action string, retryAfter time.Duration) RateLimited {
+	RateLimitedCounter.WithLabelValues(action).Inc()
+	return RateLimited{
+		Action:     action,
+		RetryAfter: retryAfter.Seconds(),
+	}
+}
+
+type ipLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ipLimiters hands out one token bucket per client IP
+type ipLimiters struct {
+	rate  float64
+	burst int
+
+	mutex       sync.Mutex
+	limiters    map[string]*ipLimiter
+	lastCleanup time.Time
+}
+
+func newIPLimiters(r float64, burst int) *ipLimiters {
+	return &ipLimiters{
+		rate:        r,
+		burst:       burst,
+		limiters:    make(map[string]*ipLimiter),
+		lastCleanup: time.Now(),
+	}
+}
+
+func (l *ipLimiters) get(ip string) *rate.Limiter {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastCleanup) > ipLimiterExpiry {
+		for k, v := range l.limiters {
+			if now.Sub(v.lastSeen) > ipLimiterExpiry {
+				delete(l.limiters, k)
+			}
+		}
+		l.lastCleanup = now
+	}
+
+	limiter, ok := l.limiters[ip]
+	if !ok {
+		limiter = &ipLimiter{
+			limiter: newLimiter(l.rate, l.burst),
+		}
+		l.limiters[ip] = limiter
+	}
+	limiter.lastSeen = now
+	return limiter.limiter
+}
+
+// clientIP returns the IP of the client. RealIP middleware already took care of proxy headers
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// limitByIP rejects requests with 429 once an IP exceeded its rate limit
+func (s *Server) limitByIP(limiters *ipLimiters, action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, retryAfter := allow(limiters.get(clientIP(r)))
+			if ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			s.log.Infof("Rate limited %s for %s", action, clientIP(r))
+			json, err := json.Marshal(newRateLimited(action, retryAfter))
+			if err != nil {
+				http.Error(w, http.StatusText(500), 500)
+				s.log.Errorf("Error encoding rate limit json: %v", err)
+				return
+			}
+			w.Header().Add("Content-Type", "application/json")
+			w.Header().Add("Retry-After", fmt.Sprintf("%d", int(math.Ceil(retryAfter.Seconds()))))
+			w.WriteHeader(429)
+			w.Write(json)
+		})
+	}
+}
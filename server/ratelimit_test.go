@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNewLimiterUnlimited(t *testing.T) {
+	limiter := newLimiter(0, 0)
+	for i := 0; i < 1000; i++ {
+		if ok, _ := allow(limiter); !ok {
+			t.Fatalf("request %d was limited", i)
+		}
+	}
+}
+
+func TestAllow(t *testing.T) {
+	limiter := newLimiter(1, 3)
+	for i := 0; i < 3; i++ {
+		if ok, _ := allow(limiter); !ok {
+			t.Fatalf("request %d of the burst was limited", i)
+		}
+	}
+	ok, retryAfter := allow(limiter)
+	if ok {
+		t.Fatal("the burst has been exceeded")
+	}
+	if retryAfter <= 0 {
+		t.Errorf("got retry after %v", retryAfter)
+	}
+	// a rejected request doesn't use up a token
+	if _, again := allow(limiter); again > retryAfter {
+		t.Errorf("waiting %v after waiting %v", again, retryAfter)
+	}
+}
+
+func TestLimitByIP(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	s := &Server{log: logger}
+	handler := s.limitByIP(newIPLimiters(1, 2), "createRoom")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	request := func(addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/rooms", nil)
+		req.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := request("192.0.2.1:1234"); w.Code != http.StatusCreated {
+			t.Fatalf("request %d got %d", i, w.Code)
+		}
+	}
+	// the port doesn't matter
+	w := request("192.0.2.1:5678")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("got Retry-After %q", w.Header().Get("Retry-After"))
+	}
+	var limited RateLimited
+	if err := json.Unmarshal(w.Body.Bytes(), &limited); err != nil {
+		t.Fatal(err)
+	}
+	if limited.Action != "createRoom" || limited.RetryAfter <= 0 {
+		t.Errorf("got %+v", limited)
+	}
+	// other IPs have their own limit
+	if w := request("192.0.2.2:1234"); w.Code != http.StatusCreated {
+		t.Errorf("another IP got %d", w.Code)
+	}
+}
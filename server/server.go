@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi"
@@ -27,19 +28,34 @@ type Server struct {
 	router      chi.Router
 	log         *log.Logger
 	roomManager *rooms.Manager
+	// rate limits per client IP
+	connectLimiters    *ipLimiters
+	roomCreateLimiters *ipLimiters
+	// probabilities are as expensive as rolls
+	probabilityLimiters *ipLimiters
+	// connections keeps track of all running websocket handlers
+	connections sync.WaitGroup
 }
 
-// NewServer returns a new server
-func NewServer(conf config.Config) *Server {
+// NewServer returns a new server. Cancelling ctx will shut down all rooms
+func NewServer(ctx context.Context, conf config.Config) *Server {
 	log := logrus.New()
 	if conf.Debug {
 		log.SetLevel(logrus.DebugLevel)
 	}
+	var storage rooms.Storage
+	if conf.StateDir != "" {
+		storage = rooms.NewDirStorage(conf.StateDir, log)
+	}
 	server := &Server{
 		conf:        conf,
 		router:      chi.NewRouter(),
-		roomManager: rooms.NewManager(log),
+		roomManager: rooms.NewManager(ctx, log, conf, storage),
 		log:         log,
+
+		connectLimiters:     newIPLimiters(conf.ConnectRate, conf.ConnectBurst),
+		roomCreateLimiters:  newIPLimiters(conf.RoomCreateRate, conf.RoomCreateBurst),
+		probabilityLimiters: newIPLimiters(conf.RollRate, conf.RollBurst),
 	}
 
 	r := server.router
@@ -50,11 +66,12 @@ func NewServer(conf config.Config) *Server {
 	r.Use(middleware.Recoverer)
 
 	r.Handle("/metrics", promhttp.Handler())
-	r.Get("/rooms/{roomName}/websocket", server.websocketHandler)
+	r.With(server.limitByIP(server.connectLimiters, "connect")).Get("/rooms/{roomName}/websocket", server.websocketHandler)
 	r.Group(func(r chi.Router) {
 		// set timeout for non classic http calls
 		r.Use(middleware.Timeout(60 * time.Second))
 		server.mountRestRoutes(r)
+		server.mountAdminRoutes(r)
 		server.mountFileRoutes(r)
 
 	})
@@ -73,6 +90,21 @@ func redirect(w http.ResponseWriter, req *http.Request) {
 		http.StatusFound)
 }
 
+func (s *Server) waitForConnections(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.connections.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Run runs the server
 func (s *Server) Run(ctx context.Context) error {
 	var httpsServer *http.Server
@@ -132,18 +164,30 @@ func (s *Server) Run(ctx context.Context) error {
 
 	prometheus.MustRegister(rooms.RoomsGauge)
 	prometheus.MustRegister(ConnectionsGauge)
+	prometheus.MustRegister(RateLimitedCounter)
 
 	select {
 	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.conf.ShutdownTimeout)
+		defer cancel()
+		// rooms have to go first. they tell all websocket clients what is going on and close the connections
+		err := s.roomManager.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Println("roomManager.Shutdown:", err)
+		}
+		err = s.waitForConnections(shutdownCtx)
+		if err != nil {
+			log.Println("waitForConnections:", err)
+		}
 		if httpsServer != nil {
-			err := httpsServer.Close()
+			err := httpsServer.Shutdown(shutdownCtx)
 			if err != nil {
-				log.Println("httpsServer.Close:", err)
+				log.Println("httpsServer.Shutdown:", err)
 			}
 		}
-		err := httpServer.Close()
+		err = httpServer.Shutdown(shutdownCtx)
 		if err != nil {
-			log.Println("httpServer.Close:", err)
+			log.Println("httpServer.Shutdown:", err)
 		}
 		return nil
 	case err := <-errCh:
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/m0ppers/wuerfler/rooms"
+)
+
+// maxTableSize limits uploaded tables to 1MB
+const maxTableSize = 1 << 20
+
+func (s *Server) mountTableRoutes(r chi.Router) {
+	r.Route("/api/rooms/{roomName}/tables", func(r chi.Router) {
+		r.Get("/", s.listTables)
+		r.Get("/{tableName}", s.getTable)
+		r.Put("/{tableName}", s.putTable)
+		r.Delete("/{tableName}", s.deleteTable)
+	})
+}
+
+// ownerToken is the resume token of the room owner sent as bearer token
+func ownerToken(r *http.Request) string {
+	return strings.TrimPrefix(strings.TrimSpace(r.Header.Get("Authorization")), "Bearer ")
+}
+
+func (s *Server) listTables(w http.ResponseWriter, req *http.Request) {
+	names, err := s.roomManager.Tables(chi.URLParam(req, "roomName"), ownerToken(req))
+	if err != nil {
+		s.writeRoomError(w, err)
+		return
+	}
+	s.writeJSON(w, 200, names)
+}
+
+func (s *Server) getTable(w http.ResponseWriter, req *http.Request) {
+	table, err := s.roomManager.Table(chi.URLParam(req, "roomName"), ownerToken(req), chi.URLParam(req, "tableName"))
+	if err != nil {
+		s.writeRoomError(w, err)
+		return
+	}
+	s.writeJSON(w, 200, &table)
+}
+
+func (s *Server) putTable(w http.ResponseWriter, req *http.Request) {
+	body := http.MaxBytesReader(w, req.Body, maxTableSize)
+	var table rooms.Table
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType == "text/csv" {
+		table, err = parseCSVTable(body, req.URL.Query().Get("die"))
+	} else {
+		err = json.NewDecoder(body).Decode(&table)
+	}
+	if err == nil {
+		err = table.Validate()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	err = s.roomManager.SetTable(chi.URLParam(req, "roomName"), ownerToken(req), chi.URLParam(req, "tableName"), table)
+	if err != nil {
+		s.writeRoomError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (s *Server) deleteTable(w http.ResponseWriter, req *http.Request) {
+	err := s.roomManager.DeleteTable(chi.URLParam(req, "roomName"), ownerToken(req), chi.URLParam(req, "tableName"))
+	if err != nil {
+		s.writeRoomError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// parseCSVTable reads a table exported from a spreadsheet. The first row names the columns:
+// weight or roll (like 1-3 or 4), result and optionally table
+func parseCSVTable(r io.Reader, die string) (rooms.Table, error) {
+	table := rooms.Table{Die: die, Entries: make([]rooms.TableEntry, 0)}
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return table, errors.New("Missing header")
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["result"]; !ok {
+		return table, errors.New("Missing result column")
+	}
+	_, hasRoll := columns["roll"]
+	_, hasWeight := columns["weight"]
+	if hasRoll == (die == "") || hasWeight == hasRoll {
+		return table, errors.New("Tables need either a weight column or a roll column and a die")
+	}
+
+	column := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return table, err
+		}
+		entry := rooms.TableEntry{
+			Result: column(record, "result"),
+			Table:  column(record, "table"),
+		}
+		if hasWeight {
+			entry.Weight, err = strconv.Atoi(column(record, "weight"))
+		} else {
+			entry.From, entry.To, err = parseRange(column(record, "roll"))
+		}
+		if err != nil {
+			return table, err
+		}
+		table.Entries = append(table.Entries, entry)
+	}
+	return table, nil
+}
+
+func parseRange(s string) (int, int, error) {
+	parts := strings.SplitN(s, "-", 2)
+	from, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid roll %s", s)
+	}
+	if len(parts) == 1 {
+		return from, from, nil
+	}
+	to, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid roll %s", s)
+	}
+	return from, to, nil
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/m0ppers/wuerfler/rooms"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
 )
 
 // ErrorMessage is being sent whenever there is an error
@@ -25,9 +26,76 @@ type Message struct {
 	Payload json.RawMessage `json:"payload"`
 }
 
-// RollPayload contains the requested dices
+// RollPayload contains the requested dices. It is either an expression like "4d6kh3+2", a list of dices
+// or an object with one of them and a target number.
+// A dice in the list is either its number of sides (6) or a die name ("d6", "dF")
 type RollPayload struct {
-	Dices []uint8 `json:"dices"`
+	Expression string        `json:"expression"`
+	Dices      []interface{} `json:"dice"`
+	Target     *int          `json:"target"`
+	Mode       string        `json:"mode"`
+	Times      int           `json:"times"`
+	// As is the ID of an NPC the owner rolls for
+	As     string `json:"as"`
+	Secret bool   `json:"secret"`
+}
+
+func (p RollPayload) request() (rooms.RollRequest, error) {
+	request := rooms.RollRequest{Expression: p.Expression, Dice: make([]string, 0, len(p.Dices)), Target: p.Target, Mode: p.Mode, Times: p.Times, As: p.As, Secret: p.Secret}
+	for _, dice := range p.Dices {
+		switch dice := dice.(type) {
+		case float64:
+			request.Dice = append(request.Dice, fmt.Sprintf("d%d", int(dice)))
+		case string:
+			request.Dice = append(request.Dice, dice)
+		default:
+			return request, errors.New("Invalid Dices")
+		}
+	}
+	return request, nil
+}
+
+// JoinPayload is the payload of the initial join message. Older clients just send their name as string
+type JoinPayload struct {
+	Name      string `json:"name"`
+	Token     string `json:"token"`
+	Spectator bool   `json:"spectator"`
+}
+
+// requestTypes maps websocket message types to the requests which are forwarded to the room as they are
+var requestTypes = map[string]func() interface{}{
+	"kick": func() interface{} { return &rooms.KickRequest{} },
+	"updateSettings": func() interface{} {
+		return &rooms.UpdateSettingsRequest{RoomSettings: rooms.DefaultSettings()}
+	},
+	"createDeck":     func() interface{} { return &rooms.CreateDeckRequest{} },
+	"shuffle":        func() interface{} { return &rooms.ShuffleRequest{} },
+	"reshuffle":      func() interface{} { return &rooms.ReshuffleRequest{} },
+	"draw":           func() interface{} { return &rooms.DrawRequest{} },
+	"discard":        func() interface{} { return &rooms.DiscardRequest{} },
+	"rollTable":      func() interface{} { return &rooms.RollTableRequest{} },
+	"probability":    func() interface{} { return &rooms.ProbabilityRequest{} },
+	"rollCall":       func() interface{} { return &rooms.RollCallRequest{} },
+	"answerRollCall": func() interface{} { return &rooms.AnswerRollCallRequest{} },
+	"challenge":      func() interface{} { return &rooms.ChallengeRequest{} },
+	"rollContest":    func() interface{} { return &rooms.ContestRollRequest{} },
+	"runMacro":       func() interface{} { return &rooms.RunMacroRequest{} },
+	"createNPC":      func() interface{} { return &rooms.CreateNPCRequest{} },
+	"removeNPC":      func() interface{} { return &rooms.RemoveNPCRequest{} },
+	"reveal":         func() interface{} { return &rooms.RevealRequest{} },
+	"updateCounter":  func() interface{} { return &rooms.UpdateCounterRequest{} },
+	"tag":            func() interface{} { return &rooms.TagRequest{} },
+	"turn":           func() interface{} { return &rooms.TurnRequest{} },
+	"timer":          func() interface{} { return &rooms.TimerRequest{} },
+}
+
+// rollingRequests are requests which roll dice or are as expensive. They are limited like rolls
+var rollingRequests = map[string]bool{
+	"probability":    true,
+	"answerRollCall": true,
+	"runMacro":       true,
+	"rollTable":      true,
+	"rollContest":    true,
 }
 
 var upgrader = websocket.Upgrader{
@@ -46,15 +114,6 @@ var (
 const (
 	// Time allowed to write a message to the peer.
 	writeWait = 10 * time.Second
-
-	// Time allowed to read the next pong message from the peer.
-	pongWait = 60 * time.Second
-
-	// Send pings to peer with this period. Must be less than pongWait.
-	pingPeriod = (pongWait * 9) / 10
-
-	// Maximum message size allowed from peer.
-	maxMessageSize = 512
 )
 
 func (s *Server) writeWebsocketError(conn *websocket.Conn, externalErr error, internalErr error) error {
@@ -69,55 +128,122 @@ func (s *Server) writeWebsocketError(conn *websocket.Conn, externalErr error, in
 	return err
 }
 
-func (s *Server) runWebsocketReader(done chan<- struct{}, conn *websocket.Conn, roll chan<- []uint8, profileUpdate chan<- string) {
+// reportError hands an error for the client to the writer. Errors are dropped if the client doesn't keep up
+func (s *Server) reportError(clientErrors chan<- error, externalErr error, internalErr error) {
+	s.log.Errorf("%v: %v", externalErr, internalErr)
+	select {
+	case clientErrors <- externalErr:
+	default:
+	}
+}
+
+// limitMessage checks a connection's token bucket and reports to the client if it is exhausted
+func limitMessage(limiter *rate.Limiter, action string, rateLimited chan<- RateLimited) bool {
+	ok, retryAfter := allow(limiter)
+	if ok {
+		return true
+	}
+	select {
+	case rateLimited <- newRateLimited(action, retryAfter):
+	default:
+		// client is flooding us. it already knows
+	}
+	return false
+}
+
+func (s *Server) runWebsocketReader(done chan<- struct{}, conn *websocket.Conn, roller rooms.Roller, rateLimited chan<- RateLimited, clientErrors chan<- error) {
 	defer func() {
 		var d struct{}
 		done <- d
 		s.log.Debug("Reader done")
 	}()
 
-	conn.SetReadLimit(maxMessageSize)
+	pongWait := s.conf.PongWait
+	conn.SetReadLimit(s.conf.MaxMessageSize)
 	conn.SetReadDeadline(time.Now().Add(pongWait))
 	conn.SetPongHandler(func(string) error { conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
 
+	messageLimiter := newLimiter(s.conf.MessageRate, s.conf.MessageBurst)
+	rollLimiter := newLimiter(s.conf.RollRate, s.conf.RollBurst)
+
 	var message Message
 	for {
 		err := conn.ReadJSON(&message)
 		if err != nil {
-			s.writeWebsocketError(conn, errors.New("Couldn't read JSON"), err)
+			s.reportError(clientErrors, errors.New("Couldn't read JSON"), err)
 			return
 		}
 
+		if !limitMessage(messageLimiter, "message", rateLimited) {
+			continue
+		}
+
 		switch message.Type {
 		case "roll":
-			dices := make([]uint8, 0)
-			err = json.Unmarshal(message.Payload, &dices)
+			if !limitMessage(rollLimiter, "roll", rateLimited) {
+				continue
+			}
+			var dices RollPayload
+			err = json.Unmarshal(message.Payload, &dices.Expression)
+			if err != nil {
+				err = json.Unmarshal(message.Payload, &dices.Dices)
+			}
+			if err != nil {
+				err = json.Unmarshal(message.Payload, &dices)
+			}
 
 			if err != nil {
-				s.writeWebsocketError(conn, errors.New("Internal Error"), err)
+				s.reportError(clientErrors, errors.New("Internal Error"), err)
 				return
 			}
-			for _, dice := range dices {
-				switch dice {
-				case 4, 6, 8, 10, 12, 20, 100:
-				default:
-					s.writeWebsocketError(conn, errors.New("Invalid Dices"), nil)
-				}
-
+			if len(dices.Dices) > s.conf.MaxDicePerRoll {
+				s.reportError(clientErrors, errors.New("Too many dices"), nil)
+				continue
+			}
+			request, err := dices.request()
+			if err != nil {
+				s.reportError(clientErrors, err, nil)
+				continue
+			}
+			select {
+			case roller.RollRequestChan <- request:
+			case <-roller.Done:
+				return
 			}
-			roll <- dices
 		case "profileUpdate":
 			var newName string
 			err = json.Unmarshal(message.Payload, &newName)
 
 			if err != nil {
-				s.writeWebsocketError(conn, errors.New("Internal Error"), err)
+				s.reportError(clientErrors, errors.New("Internal Error"), err)
 				return
 			}
 
-			profileUpdate <- newName
+			select {
+			case roller.ProfileUpdate <- newName:
+			case <-roller.Done:
+				return
+			}
 		default:
-			s.log.Warnf("Unhandled message type %s", message.Type)
+			newRequest, ok := requestTypes[message.Type]
+			if !ok {
+				s.log.Warnf("Unhandled message type %s", message.Type)
+				continue
+			}
+			if rollingRequests[message.Type] && !limitMessage(rollLimiter, "roll", rateLimited) {
+				continue
+			}
+			request := newRequest()
+			err = json.Unmarshal(message.Payload, request)
+			if err != nil {
+				s.reportError(clientErrors, errors.New("Internal Error"), err)
+				return
+			}
+			select {
+			case roller.Requests <- request:
+			case <-roller.Done:
+				return
+			}
 		}
 
 	}
@@ -143,8 +269,28 @@ func (s *Server) writeMessage(conn *websocket.Conn, t string, p interface{}) err
 	return nil
 }
 
-func (s *Server) runWebsocketWriter(done chan<- struct{}, conn *websocket.Conn, rollInfo <-chan rooms.RollResults, usersUpdateChan <-chan rooms.UsersUpdateInfo) {
-	ticker := time.NewTicker(pingPeriod)
+func (s *Server) writeDisconnect(conn *websocket.Conn, reason rooms.DisconnectReason) {
+	// the roller simply left. nobody is listening anymore
+	if reason.Reason == "" {
+		return
+	}
+	if err := s.writeMessage(conn, "disconnect", &reason); err != nil {
+		s.log.Error(err)
+		return
+	}
+	code := websocket.CloseGoingAway
+	if reason.Reason == rooms.DisconnectKicked || reason.Reason == rooms.DisconnectBanned {
+		code = websocket.ClosePolicyViolation
+	}
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	closeMessage := websocket.FormatCloseMessage(code, reason.Message)
+	if err := conn.WriteMessage(websocket.CloseMessage, closeMessage); err != nil {
+		s.log.Error(err)
+	}
+}
+
+func (s *Server) runWebsocketWriter(done chan<- struct{}, conn *websocket.Conn, roller rooms.Roller, rateLimited <-chan RateLimited, clientErrors <-chan error) {
+	ticker := time.NewTicker(s.conf.PingPeriod)
 	defer func() {
 		ticker.Stop()
 		s.log.Debug("Writer Done")
@@ -153,16 +299,34 @@ func (s *Server) runWebsocketWriter(done chan<- struct{}, conn *websocket.Conn,
 	}()
 	for {
 		select {
-		case roll := <-rollInfo:
+		case <-roller.Done:
+			s.writeDisconnect(conn, roller.DisconnectReason())
+			return
+		case roll := <-roller.RollResultsChan:
 			if err := s.writeMessage(conn, "roll", &roll); err != nil {
 				s.log.Error(err)
 				return
 			}
-		case usersUpdate := <-usersUpdateChan:
+		case usersUpdate := <-roller.UsersUpdate:
 			if err := s.writeMessage(conn, "usersupdate", &usersUpdate); err != nil {
 				s.log.Error(err)
 				return
 			}
+		case event := <-roller.Events:
+			if err := s.writeMessage(conn, event.Type, event.Payload); err != nil {
+				s.log.Error(err)
+				return
+			}
+		case clientErr := <-clientErrors:
+			if err := s.writeMessage(conn, "error", clientErr.Error()); err != nil {
+				s.log.Error(err)
+				return
+			}
+		case limited := <-rateLimited:
+			if err := s.writeMessage(conn, "ratelimited", &limited); err != nil {
+				s.log.Error(err)
+				return
+			}
 		case <-ticker.C:
 			conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -180,10 +344,12 @@ func (s *Server) websocketHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(500), 500)
 		return
 	}
+	s.connections.Add(1)
 	ConnectionsGauge.Inc()
 	defer func() {
 		conn.Close()
 		ConnectionsGauge.Dec()
+		s.connections.Done()
 	}()
 
 	var message Message
@@ -198,8 +364,11 @@ func (s *Server) websocketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var name string
-	err = json.Unmarshal(message.Payload, &name)
+	var join JoinPayload
+	err = json.Unmarshal(message.Payload, &join.Name)
+	if err != nil {
+		err = json.Unmarshal(message.Payload, &join)
+	}
 
 	if err != nil {
 		s.writeWebsocketError(conn, errors.New("Internal Error"), err)
@@ -207,17 +376,46 @@ func (s *Server) websocketHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	roomName := chi.URLParam(r, "roomName")
-	roller, err := s.roomManager.AddRoller(roomName, name)
+	roller, err := s.roomManager.AddRoller(roomName, rooms.JoinRequest{
+		Name:      join.Name,
+		Token:     join.Token,
+		IP:        clientIP(r),
+		Spectator: join.Spectator,
+	})
+	var addRollerErr *rooms.AddRollerError
+	if errors.As(err, &addRollerErr) {
+		switch addRollerErr.Type {
+		case rooms.AddRollerErrorRoomClosed:
+			s.writeDisconnect(conn, rooms.DisconnectReason{
+				Reason:  rooms.DisconnectRoomClosed,
+				Message: "Room closed",
+			})
+			return
+		case rooms.AddRollerErrorBanned:
+			s.writeDisconnect(conn, rooms.DisconnectReason{
+				Reason:  rooms.DisconnectBanned,
+				Message: "You have been banned from this room",
+			})
+			return
+		}
+	}
 	if err != nil {
 		s.writeWebsocketError(conn, errors.New("Internal Error"), err)
 		return
 	}
 
-	done := make(chan struct{})
-	go s.runWebsocketReader(done, conn, roller.RollRequestChan, roller.ProfileUpdate)
-	go s.runWebsocketWriter(done, conn, roller.RollResultsChan, roller.UsersUpdate)
+	// both reader and writer will report. only the first one is waited for
+	done := make(chan struct{}, 2)
+	// the reader may not write itself so it hands rate limit notifications and errors to the writer
+	rateLimited := make(chan RateLimited, 4)
+	clientErrors := make(chan error, 4)
+	go s.runWebsocketReader(done, conn, roller, rateLimited, clientErrors)
+	go s.runWebsocketWriter(done, conn, roller, rateLimited, clientErrors)
 	<-done
 
 	var remove struct{}
-	roller.RemoveSelf <- remove
+	select {
+	case roller.RemoveSelf <- remove:
+	case <-roller.Done:
+	}
 }